@@ -17,13 +17,16 @@ limitations under the License.
 package main
 
 import (
+	"context"
 	"flag"
 	"fmt"
 	"io/ioutil"
 	"net/http"
 	"os"
 	"os/signal"
+	"reflect"
 	"sort"
+	"strconv"
 	"strings"
 	"sync"
 	"syscall"
@@ -31,17 +34,19 @@ import (
 
 	yaml "gopkg.in/yaml.v2"
 
-	rcon "github.com/galexrt/go-rcon"
 	"github.com/galexrt/srcds_exporter/collector"
 	"github.com/galexrt/srcds_exporter/connector"
+	"github.com/galexrt/srcds_exporter/secrets"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/prometheus/common/model"
 	"github.com/prometheus/common/version"
 	"github.com/sirupsen/logrus"
 )
 
 const (
-	defaultCollectors = "map,players,rank"
+	defaultCollectors    = "map,players,rank"
+	defaultSecretRefresh = "5m"
 )
 
 var (
@@ -53,6 +58,9 @@ var (
 	metricsAddr       string
 	metricsPath       string
 	configFile        string
+	timeoutOffset     time.Duration
+	scrapeConcurrency int
+	serverTimeout     time.Duration
 )
 
 var (
@@ -85,12 +93,16 @@ var (
 var (
 	log         = logrus.New()
 	connections *connector.Connector
+	poller      = collector.NewPoller()
+	engine      *collector.Engine
+	collectors  = &SRCDSCollector{collectors: map[string]collector.Collector{}}
 )
 
 // CurrentConfig current config with a mutex
 type CurrentConfig struct {
 	sync.RWMutex
-	C *Config
+	C        *Config
+	resolver *secrets.Resolver
 }
 
 // Config Config file structure
@@ -101,20 +113,58 @@ type Config struct {
 
 // Options Options structure
 type Options struct {
-	RconTimeout        string `yaml:"rcontimeout"`
-	CacheTimeout       string `yaml:"cachetimeout"`
+	RconTimeout  string `yaml:"rcontimeout"`
+	CacheTimeout string `yaml:"cachetimeout"`
+	// CacheTimeouts overrides the CacheTimeout per rcon command
+	CacheTimeouts map[string]string `yaml:"cachetimeouts"`
+	// Keepalive interval in which idle rcon sessions are probed
+	Keepalive string `yaml:"keepalive"`
+	// MaxSessionAge rcon sessions older than this are reopened
+	MaxSessionAge string `yaml:"maxsessionage"`
+	// AuthFailureBudget rcon authentication failures tolerated within the
+	// AuthFailureWindow before the exporter stops connecting to a server
+	AuthFailureBudget  int    `yaml:"authfailurebudget"`
+	AuthFailureWindow  string `yaml:"authfailurewindow"`
 	BattleMetricsQuery string `yaml:"battlemetrics_query"`
+	// SecretRefresh interval in which RCON passwords are resolved again
+	SecretRefresh string `yaml:"secretrefresh"`
+	// Vault enables the `vault` secret provider
+	Vault *secrets.VaultOptions `yaml:"vault"`
+	// PollInterval polls the servers in the background, scrapes are served
+	// from the latest snapshot. The PollJitter spreads the polls.
+	PollInterval string `yaml:"pollinterval"`
+	PollJitter   string `yaml:"polljitter"`
 }
 
 // Server Server structure
 type Server struct {
-	Address      string `yaml:"address"`
-	RconPassword string `yaml:"rconpassword"`
+	Address string `yaml:"address"`
+	// RconPassword supports `${ENV}` expansion, alternatively the password
+	// can be read from a file or a secret provider (`<provider>:<ref>`)
+	RconPassword       string `yaml:"rconpassword"`
+	RconPasswordFile   string `yaml:"rconpassword_file"`
+	RconPasswordSecret string `yaml:"rconpassword_secret"`
+	Protocol           string `yaml:"protocol"`
+	// RconTimeout and CacheTimeout override the global options
+	RconTimeout  string `yaml:"rcontimeout"`
+	CacheTimeout string `yaml:"cachetimeout"`
+	// PollInterval and PollJitter override the global options
+	PollInterval string `yaml:"pollinterval"`
+	PollJitter   string `yaml:"polljitter"`
+	// PacketGap how long GoldSrc responses are waited for further packets
+	PacketGap string `yaml:"packetgap"`
+	// Collectors overrides the collectors enabled by --collectors.enabled
+	Collectors []string `yaml:"collectors"`
+	// Labels additional labels added to all metrics of the server
+	Labels map[string]string `yaml:"labels"`
 }
 
 // SRCDSCollector SRCDS Collector map structure
 type SRCDSCollector struct {
+	mu         sync.RWMutex
 	collectors map[string]collector.Collector
+	// labels names of the additional labels the collectors were created with
+	labels []string
 }
 
 func init() {
@@ -126,6 +176,9 @@ func init() {
 	flag.StringVar(&metricsPath, "web.telemetry-path", "/metrics", "Path the metrics will be exposed under")
 	flag.StringVar(&enabledCollectors, "collectors.enabled", defaultCollectors, "Comma separated list of active collectors")
 	flag.StringVar(&configFile, "config.file", "./srcds.yaml", "Config file to use.")
+	flag.DurationVar(&timeoutOffset, "web.timeout-offset", 500*time.Millisecond, "Offset to subtract from the Prometheus scrape timeout to leave time for the response")
+	flag.IntVar(&scrapeConcurrency, "scrape.concurrency", 16, "Maximum number of servers queried at once per scrape")
+	flag.DurationVar(&serverTimeout, "scrape.server-timeout", 10*time.Second, "Maximum time a server is queried for per scrape, 0 only limits the whole scrape")
 }
 
 func (cc *CurrentConfig) reloadConfig(confFile string) (err error) {
@@ -142,34 +195,232 @@ func (cc *CurrentConfig) reloadConfig(confFile string) (err error) {
 		return err
 	}
 
+	if err := validateConfig(c); err != nil {
+		log.Errorf("Error validating config file: %s", err)
+		return err
+	}
+	resolver, err := newResolver(c)
+	if err != nil {
+		log.Errorf("Error configuring secret providers: %s", err)
+		return err
+	}
+	passwords, errs := resolvePasswords(resolver, c)
+	for name, err := range errs {
+		// a temporary outage of the secret provider doesn't take down the
+		// connected servers
+		if password, ok := connections.Password(name); ok {
+			log.Errorf("Error resolving rcon password of server %s, keeping the previous password: %s", name, err)
+			passwords[name] = password
+			continue
+		}
+		log.Errorf("Error resolving rcon password of server %s, the server isn't connected: %s", name, err)
+	}
+
 	cc.Lock()
+	defer cc.Unlock()
+	labels := labelNames(c)
+	loaded, err := collectors.load(neededCollectors(c), labels)
+	if err != nil {
+		log.Errorf("Error loading collectors: %s", err)
+		return err
+	}
+	if err := checkCollectors(loaded, labels); err != nil {
+		log.Errorf("Error registering collectors: %s", err)
+		return err
+	}
+	result, err := connections.Reload(serverOptions(c, passwords))
+	if err != nil {
+		log.Errorf("Error applying config file: %s", err)
+		return err
+	}
+	collectors.set(loaded, labels)
+	servers, err := connections.GetConnections()
+	if err != nil {
+		log.Errorf("Error getting connections: %s", err)
+		return err
+	}
+	poller.Sync(servers)
 	cc.C = c
-	loadConnections(cc)
-	cc.Unlock()
+	cc.resolver = resolver
 
-	log.Infoln("Loaded config file")
+	log.Infof("Loaded config file (added: %v, removed: %v, changed: %v, renamed: %v)",
+		result.Added, result.Removed, result.Changed, result.Renamed)
 	return nil
 }
 
+// refreshSecrets resolves the RCON passwords of the current config again and
+// updates the changed passwords without reopening the sessions. Servers whose
+// password couldn't be resolved keep their previous password.
+func (cc *CurrentConfig) refreshSecrets() {
+	cc.RLock()
+	defer cc.RUnlock()
+	passwords, errs := resolvePasswords(cc.resolver, cc.C)
+	for name, err := range errs {
+		log.Errorf("Error refreshing rcon password of server %s, keeping the previous password: %s", name, err)
+	}
+	for name, password := range passwords {
+		changed, err := connections.UpdatePassword(name, password)
+		if err != nil {
+			log.Errorf("Error updating rcon password of server %s: %s", name, err)
+		} else if changed {
+			log.Infof("Updated rcon password of server %s", name)
+		}
+	}
+}
+
+// secretRefreshInterval returns the secret refresh interval of the current
+// config, zero disables refreshing
+func (cc *CurrentConfig) secretRefreshInterval() time.Duration {
+	cc.RLock()
+	defer cc.RUnlock()
+	refresh := cc.C.Options.SecretRefresh
+	if refresh == "" {
+		refresh = defaultSecretRefresh
+	}
+	// validated by validateConfig
+	interval, _ := time.ParseDuration(refresh)
+	return interval
+}
+
+// newResolver returns the secret resolver with the providers of the config
+func newResolver(c *Config) (*secrets.Resolver, error) {
+	resolver := secrets.NewResolver()
+	if c.Options.Vault != nil {
+		opts := *c.Options.Vault
+		token, err := secrets.ExpandEnv(opts.Token)
+		if err != nil {
+			return nil, err
+		}
+		opts.Token = token
+		vault, err := secrets.NewVaultProvider(opts)
+		if err != nil {
+			return nil, fmt.Errorf("vault: %w", err)
+		}
+		resolver.Register("vault", vault)
+	}
+	return resolver, nil
+}
+
+// resolvePasswords returns the RCON passwords of all servers and the errors
+// of the servers whose password couldn't be resolved
+func resolvePasswords(resolver *secrets.Resolver, c *Config) (map[string]string, map[string]error) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	passwords := make(map[string]string, len(c.Servers))
+	errs := map[string]error{}
+	for name, server := range c.Servers {
+		var (
+			password string
+			err      error
+		)
+		switch {
+		case server.RconPasswordFile != "":
+			password, err = resolver.Resolve(ctx, "file:"+server.RconPasswordFile)
+		case server.RconPasswordSecret != "":
+			password, err = resolver.Resolve(ctx, server.RconPasswordSecret)
+		default:
+			password, err = secrets.ExpandEnv(server.RconPassword)
+		}
+		if err != nil {
+			errs[name] = err
+			continue
+		}
+		passwords[name] = password
+	}
+	return passwords, errs
+}
+
 // Describe implements the prometheus.Collector interface.
-func (n SRCDSCollector) Describe(ch chan<- *prometheus.Desc) {
+func (n *SRCDSCollector) Describe(ch chan<- *prometheus.Desc) {
 	ch <- scrapeDurationDesc
 	ch <- scrapeSuccessDesc
+	engine.Describe(ch)
+	n.mu.RLock()
+	defer n.mu.RUnlock()
+	for _, c := range n.collectors {
+		for _, d := range c.Descs() {
+			ch <- d.Desc()
+		}
+	}
+}
+
+// scrapeCollector runs the collectors with the context of a single scrape
+type scrapeCollector struct {
+	*SRCDSCollector
+	ctx context.Context
 }
 
 // Collect implements the prometheus.Collector interface.
-func (n SRCDSCollector) Collect(ch chan<- prometheus.Metric) {
+func (s scrapeCollector) Collect(ch chan<- prometheus.Metric) {
+	s.collect(s.ctx, ch)
+}
+
+// collect queries the servers and runs all collectors concurrently until they
+// are done or ctx is done
+func (n *SRCDSCollector) collect(ctx context.Context, ch chan<- prometheus.Metric) {
+	ctx = engine.Scrape(ctx, ch)
+	n.mu.RLock()
+	collectors := n.collectors
+	n.mu.RUnlock()
 	wg := sync.WaitGroup{}
-	wg.Add(len(n.collectors))
-	for name, c := range n.collectors {
+	wg.Add(len(collectors))
+	for name, c := range collectors {
 		go func(name string, c collector.Collector) {
-			execute(name, c, ch)
+			execute(ctx, name, c, ch)
 			wg.Done()
 		}(name, c)
 	}
 	wg.Wait()
 }
 
+// load returns the given collectors, already loaded collectors are reused
+// unless the names of the additional labels changed
+func (n *SRCDSCollector) load(names []string, labels []string) (map[string]collector.Collector, error) {
+	n.mu.RLock()
+	defer n.mu.RUnlock()
+	missing := []string{}
+	loaded := map[string]collector.Collector{}
+	reuse := reflect.DeepEqual(labels, n.labels)
+	for _, name := range names {
+		if c, ok := n.collectors[name]; ok && reuse {
+			loaded[name] = c
+		} else {
+			missing = append(missing, name)
+		}
+	}
+	if len(missing) == 0 {
+		return loaded, nil
+	}
+	added, err := loadCollectors(strings.Join(missing, ","), labels)
+	if err != nil {
+		return nil, err
+	}
+	for name, c := range added {
+		log.Infof("Enabled collector %s", name)
+		loaded[name] = c
+	}
+	return loaded, nil
+}
+
+// set replaces the active collectors
+func (n *SRCDSCollector) set(collectors map[string]collector.Collector, labels []string) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.collectors = collectors
+	n.labels = labels
+}
+
+// checkCollectors registers the collectors with a pedantic registry, so a
+// config their descs are inconsistent with is rejected when it's loaded and
+// not when it's scraped
+func checkCollectors(loaded map[string]collector.Collector, labels []string) error {
+	registry := prometheus.NewPedanticRegistry()
+	return registry.Register(scrapeCollector{
+		SRCDSCollector: &SRCDSCollector{collectors: loaded, labels: labels},
+		ctx:            context.Background(),
+	})
+}
+
 func filterAvailableCollectors(collectors string) string {
 	var availableCollectors []string
 	for _, c := range strings.Split(collectors, ",") {
@@ -181,9 +432,9 @@ func filterAvailableCollectors(collectors string) string {
 	return strings.Join(availableCollectors, ",")
 }
 
-func execute(name string, c collector.Collector, ch chan<- prometheus.Metric) {
+func execute(ctx context.Context, name string, c collector.Collector, ch chan<- prometheus.Metric) {
 	begin := time.Now()
-	err := c.Update(ch)
+	err := c.Update(ctx, ch)
 	duration := time.Since(begin)
 	var success float64
 
@@ -198,14 +449,14 @@ func execute(name string, c collector.Collector, ch chan<- prometheus.Metric) {
 	ch <- prometheus.MustNewConstMetric(scrapeSuccessDesc, prometheus.GaugeValue, success, name)
 }
 
-func loadCollectors(list string) (map[string]collector.Collector, error) {
+func loadCollectors(list string, labels []string) (map[string]collector.Collector, error) {
 	collectors := map[string]collector.Collector{}
 	for _, name := range strings.Split(list, ",") {
 		fn, ok := collector.Factories[name]
 		if !ok {
 			return nil, fmt.Errorf("collector '%s' not available", name)
 		}
-		c, err := fn()
+		c, err := fn(labels)
 		if err != nil {
 			return nil, err
 		}
@@ -214,26 +465,147 @@ func loadCollectors(list string) (map[string]collector.Collector, error) {
 	return collectors, nil
 }
 
-func loadConnections(cc *CurrentConfig) *connector.Connector {
-	for name, server := range cc.C.Servers {
-		var err error
-		for i := 0; i < 5; i++ {
-			if err = connections.NewConnection(name,
-				&connector.ConnectionOptions{
-					Addr:           server.Address,
-					RconPassword:   server.RconPassword,
-					ConnectTimeout: cc.C.Options.RconTimeout,
-					CacheTimeout:   cc.C.Options.CacheTimeout,
-				}); err == nil {
-				break
+// validateConfig checks the per server password sources, collectors and
+// labels
+func validateConfig(c *Config) error {
+	if c.Options.SecretRefresh != "" {
+		if _, err := time.ParseDuration(c.Options.SecretRefresh); err != nil {
+			return fmt.Errorf("invalid secretrefresh: %w", err)
+		}
+	}
+	for name, server := range c.Servers {
+		sources := 0
+		for _, source := range []string{server.RconPassword, server.RconPasswordFile, server.RconPasswordSecret} {
+			if source != "" {
+				sources++
+			}
+		}
+		if sources > 1 {
+			return fmt.Errorf("server %s: only one of rconpassword, rconpassword_file and rconpassword_secret can be set", name)
+		}
+		for _, n := range server.Collectors {
+			if _, ok := collector.Factories[n]; !ok {
+				return fmt.Errorf("server %s: collector '%s' not available", name, n)
+			}
+		}
+		for label := range server.Labels {
+			if !model.LabelName(label).IsValid() || strings.HasPrefix(label, model.ReservedLabelPrefix) {
+				return fmt.Errorf("server %s: invalid label name '%s'", name, label)
+			}
+			// histograms and summaries use le and quantile
+			if label == model.BucketLabel || label == model.QuantileLabel {
+				return fmt.Errorf("server %s: label name '%s' is reserved", name, label)
+			}
+			if label == "server" {
+				return fmt.Errorf("server %s: label '%s' is set by the exporter", name, label)
+			}
+		}
+	}
+	return nil
+}
+
+// effectiveServers returns the servers with the global options applied
+func effectiveServers(c *Config) map[string]Server {
+	servers := make(map[string]Server, len(c.Servers))
+	for name, server := range c.Servers {
+		if server.RconTimeout == "" {
+			server.RconTimeout = c.Options.RconTimeout
+		}
+		if server.CacheTimeout == "" {
+			server.CacheTimeout = c.Options.CacheTimeout
+		}
+		if server.PollInterval == "" {
+			server.PollInterval = c.Options.PollInterval
+		}
+		if server.PollJitter == "" {
+			server.PollJitter = c.Options.PollJitter
+		}
+		if len(server.Collectors) == 0 {
+			server.Collectors = strings.Split(enabledCollectors, ",")
+		}
+		servers[name] = server
+	}
+	return servers
+}
+
+// labelNames returns the sorted names of the additional labels of all servers
+func labelNames(c *Config) []string {
+	seen := map[string]bool{}
+	var names []string
+	for _, server := range c.Servers {
+		for name := range server.Labels {
+			if !seen[name] {
+				seen[name] = true
+				names = append(names, name)
 			}
 		}
-		if err != nil {
-			log.Fatalf("Error connecting to server: %v", server.Address)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// neededCollectors returns the globally enabled and all per server collectors
+func neededCollectors(c *Config) []string {
+	names := strings.Split(enabledCollectors, ",")
+	seen := map[string]bool{}
+	for _, n := range names {
+		seen[n] = true
+	}
+	for _, server := range c.Servers {
+		for _, n := range server.Collectors {
+			if !seen[n] {
+				seen[n] = true
+				names = append(names, n)
+			}
+		}
+	}
+	return names
+}
+
+func serverOptions(c *Config, passwords map[string]string) map[string]*connector.ConnectionOptions {
+	servers := make(map[string]*connector.ConnectionOptions, len(c.Servers))
+	for name, server := range effectiveServers(c) {
+		_, resolved := passwords[name]
+		servers[name] = &connector.ConnectionOptions{
+			Addr:           server.Address,
+			RconPassword:   passwords[name],
+			ConnectTimeout: server.RconTimeout,
+			CacheTimeout:   server.CacheTimeout,
+			Protocol:       server.Protocol,
+			Collectors:     server.Collectors,
+			Labels:         server.Labels,
+			PollInterval:   server.PollInterval,
+			PollJitter:     server.PollJitter,
+			PacketGap:      server.PacketGap,
+
+			PasswordUnavailable:  !resolved,
+			CommandCacheTimeouts: c.Options.CacheTimeouts,
+			KeepaliveInterval:    c.Options.Keepalive,
+			MaxSessionAge:        c.Options.MaxSessionAge,
+			AuthFailureBudget:    c.Options.AuthFailureBudget,
+			AuthFailureWindow:    c.Options.AuthFailureWindow,
 		}
-		log.Debugf("Connected to server: %v", server.Address)
 	}
-	return connections
+	return servers
+}
+
+// scrapeContext returns the context for a scrape, its deadline is the scrape
+// timeout sent by Prometheus minus the timeout offset
+func scrapeContext(r *http.Request) (context.Context, context.CancelFunc) {
+	header := r.Header.Get("X-Prometheus-Scrape-Timeout-Seconds")
+	if header == "" {
+		return context.WithCancel(r.Context())
+	}
+	seconds, err := strconv.ParseFloat(header, 64)
+	if err != nil {
+		log.Warnf("Invalid scrape timeout header %q: %s", header, err)
+		return context.WithCancel(r.Context())
+	}
+	timeout := time.Duration(seconds * float64(time.Second))
+	if timeout > timeoutOffset {
+		timeout -= timeoutOffset
+	}
+	return context.WithTimeout(r.Context(), timeout)
 }
 
 func main() {
@@ -256,18 +628,28 @@ func main() {
 		fmt.Printf("Available collectors:\n")
 		for _, n := range collectorNames {
 			fmt.Printf(" - %s\n", n)
+			c, err := collector.Factories[n](nil)
+			if err != nil {
+				log.Fatalf("Couldn't create collector %s: %s", n, err)
+			}
+			for _, d := range c.Descs() {
+				fmt.Printf("     %s{%s}: %s\n", d.Name, strings.Join(d.Labels, ","), d.Help)
+			}
 		}
 		return
 	}
 	log.Out = os.Stdout
 	if debugMode {
 		log.Level = logrus.DebugLevel
+		logrus.SetLevel(logrus.DebugLevel)
 	}
-	rcon.SetLog(log)
 	log.Infoln("Starting srcds_exporter", version.Info())
 	log.Infoln("Build context", version.BuildContext())
 
 	connections = connector.NewConnector()
+	collector.SetConnector(connections)
+	collector.SetPoller(poller)
+	engine = collector.NewEngine(scrapeConcurrency, serverTimeout)
 	cc := &CurrentConfig{
 		C: &Config{},
 	}
@@ -276,7 +658,20 @@ func main() {
 		log.Fatalf("Error loading config: %s", err)
 	}
 
-	hup := make(chan os.Signal)
+	go func() {
+		for {
+			// the interval can change with config reloads
+			interval := cc.secretRefreshInterval()
+			if interval <= 0 {
+				time.Sleep(time.Minute)
+				continue
+			}
+			time.Sleep(interval)
+			cc.refreshSecrets()
+		}
+	}()
+
+	hup := make(chan os.Signal, 1)
 	reloadCh := make(chan chan error)
 	signal.Notify(hup, syscall.SIGHUP)
 	go func() {
@@ -296,30 +691,34 @@ func main() {
 			}
 		}
 	}()
-	collector.SetConnector(connections)
 	defer connections.CloseAll()
-	collectors, err := loadCollectors(enabledCollectors)
-	if err != nil {
-		log.Fatalf("Couldn't load collectors: %s", err)
-	}
-	log.Infof("Enabled collectors:")
-	for n := range collectors {
-		log.Infof(" - %s", n)
-	}
+	defer poller.Stop()
 
-	if err = prometheus.Register(SRCDSCollector{collectors: collectors}); err != nil {
-		log.Fatalf("Couldn't register collector: %s", err)
+	if err := prometheus.Register(connections); err != nil {
+		log.Fatalf("Couldn't register connector: %s", err)
+	}
+	if err := prometheus.Register(poller); err != nil {
+		log.Fatalf("Couldn't register poller: %s", err)
 	}
-	handler := promhttp.HandlerFor(prometheus.DefaultGatherer,
-		promhttp.HandlerOpts{
-			ErrorLog:      log,
-			ErrorHandling: promhttp.ContinueOnError,
-		})
-
 	http.HandleFunc(metricsPath, func(w http.ResponseWriter, r *http.Request) {
+		ctx, cancel := scrapeContext(r)
+		defer cancel()
 		cc.RLock()
+		defer cc.RUnlock()
+		// the collectors are registered per scrape to pass them its context,
+		// the registration was checked when the config was loaded. The
+		// pedantic registry checks the metrics against the descs.
+		registry := prometheus.NewPedanticRegistry()
+		if err := registry.Register(scrapeCollector{SRCDSCollector: collectors, ctx: ctx}); err != nil {
+			http.Error(w, fmt.Sprintf("failed to register collectors: %s", err), http.StatusInternalServerError)
+			return
+		}
+		handler := promhttp.HandlerFor(prometheus.Gatherers{prometheus.DefaultGatherer, registry},
+			promhttp.HandlerOpts{
+				ErrorLog:      log,
+				ErrorHandling: promhttp.ContinueOnError,
+			})
 		handler.ServeHTTP(w, r)
-		cc.RUnlock()
 	})
 	http.HandleFunc("/-/reload", func(w http.ResponseWriter, r *http.Request) {
 		if r.Method != "POST" {
@@ -330,20 +729,39 @@ func main() {
 
 		rc := make(chan error)
 		reloadCh <- rc
-		if err = <-rc; err != nil {
+		if err := <-rc; err != nil {
 			http.Error(w, fmt.Sprintf("failed to reload config: %s", err), http.StatusInternalServerError)
 		}
 	})
+	http.HandleFunc("/debug/config", func(w http.ResponseWriter, r *http.Request) {
+		cc.RLock()
+		servers := effectiveServers(cc.C)
+		cc.RUnlock()
+		for name, server := range servers {
+			if server.RconPassword != "" {
+				server.RconPassword = "<secret>"
+			}
+			servers[name] = server
+		}
+		out, err := yaml.Marshal(map[string]interface{}{"servers": servers})
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.Write(out)
+	})
 	http.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
 		w.Write([]byte(`<html>
 			<head><title>SRCDS Exporter</title></head>
 			<body>
 			<h1>SRCDS Exporter</h1>
 			<p><a href="` + metricsPath + `">Metrics</a></p>
+			<p><a href="/debug/config">Effective configuration</a></p>
 			</body>
 			</html>`))
 	})
-	err = http.ListenAndServe(metricsAddr, nil)
+	err := http.ListenAndServe(metricsAddr, nil)
 	if err != nil {
 		log.Fatal(err)
 	}
@@ -14,7 +14,7 @@ const (
 )
 
 type battleMetricsCollector struct {
-	rank []*prometheus.Desc
+	rank *Desc
 }
 
 func init() {
@@ -22,34 +22,34 @@ func init() {
 }
 
 // NewBattleMetricsCollector returns a new Collector exposing the current rankings.
-func NewBattleMetricsCollector() (Collector, error) {
-	var ranks []*prometheus.Desc
+func NewBattleMetricsCollector(labels []string) (Collector, error) {
+	// the servers are the servers listed by BattleMetrics, they have no
+	// additional labels
 	return &battleMetricsCollector{
-		rank: ranks,
+		rank: newDesc(subSystem, metricName, "The current battlemetrics server rank.",
+			prometheus.GaugeValue, nil),
 	}, nil
 }
 
-func (c *battleMetricsCollector) Update(ch chan<- prometheus.Metric) error {
-	res, err := fetch()
+func (c *battleMetricsCollector) Descs() []*Desc {
+	return []*Desc{c.rank}
+}
+
+func (c *battleMetricsCollector) Update(ctx context.Context, ch chan<- prometheus.Metric) error {
+	res, err := fetch(ctx)
 	if err != nil {
 		return err
 	}
 	for _, server := range res.State.Servers.Servers {
-		rank := prometheus.NewDesc(
-			prometheus.BuildFQName(Namespace, subSystem, metricName),
-			"The current battlemetrics server rank.",
-			nil, prometheus.Labels{
-				"server": server.Name,
-			})
 		ch <- prometheus.MustNewConstMetric(
-			rank, prometheus.GaugeValue, float64(server.Rank))
+			c.rank.Desc(), prometheus.GaugeValue, float64(server.Rank), server.Name)
 	}
 	return nil
 }
 
-func fetch() (gameTrackerState, error) {
+func fetch(ctx context.Context) (gameTrackerState, error) {
 	var state gameTrackerState
-	ctx, cancel := chromedp.NewContext(context.Background())
+	ctx, cancel := chromedp.NewContext(ctx)
 	defer cancel()
 	var res string
 	err := chromedp.Run(ctx,
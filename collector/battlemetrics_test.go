@@ -1,6 +1,7 @@
 package collector
 
 import (
+	"context"
 	"github.com/stretchr/testify/require"
 	"os/exec"
 	"testing"
@@ -11,7 +12,7 @@ func TestBMScrape(t *testing.T) {
 		t.Skipf("google-chrome not in path, skipping test")
 		return
 	}
-	result, err := fetch()
+	result, err := fetch(context.Background())
 	require.NoError(t, err)
 	require.True(t, len(result.State.Servers.Servers) > 6)
 }
@@ -17,6 +17,8 @@ limitations under the License.
 package collector
 
 import (
+	"context"
+
 	"github.com/galexrt/srcds_exporter/connector"
 	"github.com/prometheus/client_golang/prometheus"
 )
@@ -24,18 +26,32 @@ import (
 // Namespace metric namespace name
 const Namespace = "srcds"
 
-// Factories contains the list of all available collectors.
-var Factories = make(map[string]func() (Collector, error))
+// Factories contains the list of all available collectors, they are created
+// with the names of the additional labels of the servers
+var Factories = make(map[string]func(labels []string) (Collector, error))
 
 var connections *connector.Connector
 
+// poller serves the polled servers, servers are queried on every scrape if nil
+var poller *Poller
+
 // Collector is the interface a collector has to implement.
 type Collector interface {
-	// Get new metrics and expose them via prometheus registry.
-	Update(ch chan<- prometheus.Metric) error
+	// Descs returns the metrics the collector exports, the metrics sent by
+	// Update must match one of them
+	Descs() []*Desc
+	// Get new metrics and expose them via prometheus registry. Update
+	// returns when ctx is done, metrics of servers which answered in time are
+	// still exposed.
+	Update(ctx context.Context, ch chan<- prometheus.Metric) error
 }
 
 // SetConnector a given connector for the collectors
 func SetConnector(con *connector.Connector) {
 	connections = con
 }
+
+// SetPoller sets the Poller the collectors serve polled servers from
+func SetPoller(p *Poller) {
+	poller = p
+}
@@ -0,0 +1,603 @@
+/*
+Copyright 2020 Alexander Trost <galexrt@googlemail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package collector
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/galexrt/srcds_exporter/connector"
+	"github.com/galexrt/srcds_exporter/parser/models"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const fakeProtocol = "fake"
+
+// hangResponse makes the fake transport block until the context is done
+const hangResponse = "\x00hang"
+
+const tf2Status = `hostname: Test Server
+version : 6300758/24 6300758 secure
+udp/ip  : 10.0.0.1:27015  (public ip: 1.2.3.4)
+steamid : [G:1:1234567] (85568392921274567)
+account : not logged in  (No account specified)
+map     : pl_upward at: 0 x, 0 y, 0 z
+tags    : payload
+players : 2 humans, 1 bots (24 max)
+edicts  : 926 used of 2048 max
+# userid name                uniqueid            connected ping loss state  adr
+#      2 "SourceTV"          BOT                                     active
+#      3 "TestUser1"         [U:1:1015738]       07:36       65    0 active 10.10.220.12:27005
+#      4 "TestUser2"         [U:1:1234567]       00:11       74    2 active 192.168.1.5:27005
+`
+
+const tf2Stats = `CPU    In (KB/s)  Out (KB/s)  Uptime  Map changes  FPS      Players  Connects
+12.50  3.21       45.67       1234    17           66.67    24       311
+`
+
+var (
+	// fakeServers contains the canned responses per server address
+	fakeServers   = map[string]map[string]string{}
+	fakeServersMu sync.Mutex
+)
+
+// fakeTransport answers commands with the canned responses of its server
+type fakeTransport struct {
+	responses map[string]string
+}
+
+func (t *fakeTransport) Send(ctx context.Context, cmd string) (string, error) {
+	resp, ok := t.responses[cmd]
+	if !ok {
+		return "", errors.New("unknown command")
+	}
+	if resp == hangResponse {
+		<-ctx.Done()
+		return "", ctx.Err()
+	}
+	return resp, nil
+}
+
+func (t *fakeTransport) Health() error {
+	return nil
+}
+
+func (t *fakeTransport) Close() error {
+	return nil
+}
+
+func init() {
+	connector.Transports[fakeProtocol] = func(opts *connector.ConnectionOptions) (connector.Transport, error) {
+		fakeServersMu.Lock()
+		defer fakeServersMu.Unlock()
+		responses, ok := fakeServers[opts.Addr]
+		if !ok {
+			return nil, errors.New("connection refused")
+		}
+		return &fakeTransport{responses: responses}, nil
+	}
+}
+
+// setupFakeServers creates a connector for the given servers (name to canned
+// responses) and waits until all connections are established
+func setupFakeServers(t *testing.T, servers map[string]map[string]string) {
+	setupFakeServersWithOptions(t, servers, nil)
+}
+
+// setupFakeServersWithOptions is setupFakeServers with configure being called
+// with the connection options of every server
+func setupFakeServersWithOptions(t *testing.T, servers map[string]map[string]string, configure func(name string, opts *connector.ConnectionOptions)) {
+	cn := connector.NewConnector()
+	options := map[string]*connector.ConnectionOptions{}
+	fakeServersMu.Lock()
+	for name, responses := range servers {
+		fakeServers[name] = responses
+	}
+	fakeServersMu.Unlock()
+	for name, responses := range servers {
+		options[name] = fakeServerOptions(name, responses)
+		if configure != nil {
+			configure(name, options[name])
+		}
+	}
+	_, err := cn.Reload(options)
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		cn.CloseAll()
+		fakeServersMu.Lock()
+		defer fakeServersMu.Unlock()
+		for name := range servers {
+			delete(fakeServers, name)
+		}
+	})
+
+	connections, err := cn.GetConnections()
+	require.NoError(t, err)
+	for _, con := range connections {
+		deadline := time.Now().Add(5 * time.Second)
+		for con.State() != connector.StateHealthy {
+			require.True(t, time.Now().Before(deadline), "connection %s not healthy", con.Name())
+			time.Sleep(5 * time.Millisecond)
+		}
+	}
+	SetConnector(cn)
+}
+
+// fakeServerOptions returns the connection options of the fake server
+func fakeServerOptions(addr string, responses map[string]string) *connector.ConnectionOptions {
+	opts := &connector.ConnectionOptions{
+		Addr:           addr,
+		ConnectTimeout: "1s",
+		CacheTimeout:   "1s",
+		Protocol:       fakeProtocol,
+	}
+	// the snapshots of servers without stats don't query them
+	if _, ok := responses["stats"]; !ok {
+		opts.Collectors = collectorsWithout("stats")
+	}
+	return opts
+}
+
+// renameFakeServer renames the only server set up by setupFakeServers, its
+// connection is kept
+func renameFakeServer(t *testing.T, from string, to string) {
+	fakeServersMu.Lock()
+	responses := fakeServers[from]
+	fakeServersMu.Unlock()
+	result, err := connections.Reload(map[string]*connector.ConnectionOptions{
+		to: fakeServerOptions(from, responses),
+	})
+	require.NoError(t, err)
+	require.Equal(t, map[string]string{from: to}, result.Renamed)
+}
+
+// collectorsWithout returns the sorted names of all collectors except the
+// excluded one
+func collectorsWithout(excluded string) []string {
+	var collectors []string
+	for name := range Factories {
+		if name != excluded {
+			collectors = append(collectors, name)
+		}
+	}
+	sort.Strings(collectors)
+	return collectors
+}
+
+// testCollector adapts a Collector to a prometheus.Collector
+type testCollector struct {
+	c Collector
+}
+
+func (t testCollector) Describe(ch chan<- *prometheus.Desc) {
+	for _, d := range t.c.Descs() {
+		ch <- d.Desc()
+	}
+}
+
+// Collect drops the metrics per server of the collector, they are tested by
+// TestCollectorServerMetrics
+func (t testCollector) Collect(ch chan<- prometheus.Metric) {
+	metrics := make(chan prometheus.Metric, 100)
+	if err := t.c.Update(context.Background(), metrics); err != nil {
+		panic(err)
+	}
+	for _, m := range drain(metrics) {
+		ch <- m
+	}
+}
+
+// serverLabelNames returns the names of the additional labels of the servers
+func serverLabelNames(t *testing.T) []string {
+	all, err := connections.GetConnections()
+	require.NoError(t, err)
+	seen := map[string]bool{}
+	var names []string
+	for _, con := range all {
+		for name := range con.Labels() {
+			if !seen[name] {
+				seen[name] = true
+				names = append(names, name)
+			}
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// collectAndCompare compares the metrics of the collector, the pedantic
+// registry checks that they match the descs of the collector
+func collectAndCompare(t *testing.T, factory func(labels []string) (Collector, error), expected string, names ...string) {
+	c, err := factory(serverLabelNames(t))
+	require.NoError(t, err)
+	reg := prometheus.NewPedanticRegistry()
+	reg.MustRegister(testCollector{c: c})
+	assert.NoError(t, testutil.GatherAndCompare(reg, strings.NewReader(expected), names...))
+}
+
+func TestMapCollector(t *testing.T) {
+	setupFakeServers(t, map[string]map[string]string{
+		"server1": {"status": tf2Status},
+	})
+	collectAndCompare(t, NewMapCollector, `
+# HELP srcds_map_info The current map on the server, the series of the previous map is dropped on a map change.
+# TYPE srcds_map_info gauge
+srcds_map_info{map="pl_upward",server="server1"} 1
+# HELP srcds_map_changes_total The map changes on the server seen by the exporter.
+# TYPE srcds_map_changes_total counter
+srcds_map_changes_total{server="server1"} 0
+`, "srcds_map_info", "srcds_map_changes_total")
+}
+
+func TestMapCollectorTransitions(t *testing.T) {
+	c, err := NewMapCollector(nil)
+	require.NoError(t, err)
+	m := c.(*mapCollector)
+	begin := time.Unix(1000, 0)
+	server1, server2 := &connector.Connection{}, &connector.Connection{}
+
+	state := m.observe(server1, "pl_upward", begin)
+	assert.Equal(t, "pl_upward", state.current)
+	assert.Equal(t, 0, state.changes)
+	assert.Equal(t, begin, state.start)
+
+	state = m.observe(server1, "pl_upward", begin.Add(time.Minute))
+	assert.Equal(t, 0, state.changes)
+	assert.Equal(t, map[string]time.Duration{"pl_upward": time.Minute}, state.playtime)
+
+	// a cached snapshot older than the last one seen is ignored
+	state = m.observe(server1, "ctf_2fort", begin.Add(30*time.Second))
+	assert.Equal(t, "pl_upward", state.current)
+
+	state = m.observe(server1, "ctf_2fort", begin.Add(2*time.Minute))
+	assert.Equal(t, "ctf_2fort", state.current)
+	assert.Equal(t, 1, state.changes)
+	assert.Equal(t, begin.Add(2*time.Minute), state.start)
+
+	state = m.observe(server1, "pl_upward", begin.Add(5*time.Minute))
+	assert.Equal(t, 2, state.changes)
+	assert.Equal(t, map[string]time.Duration{
+		"pl_upward": time.Minute,
+		"ctf_2fort": 0,
+	}, state.playtime)
+
+	state = m.observe(server1, "pl_upward", begin.Add(6*time.Minute))
+	assert.Equal(t, 2*time.Minute, state.playtime["pl_upward"])
+
+	// the servers are tracked separately
+	state = m.observe(server2, "ctf_2fort", begin)
+	assert.Equal(t, 0, state.changes)
+}
+
+func TestMapCollectorRename(t *testing.T) {
+	setupFakeServers(t, map[string]map[string]string{
+		"server1": {"status": tf2Status},
+	})
+	c, err := NewMapCollector(nil)
+	require.NoError(t, err)
+	ch := make(chan prometheus.Metric, 10)
+	require.NoError(t, c.Update(context.Background(), ch))
+	drain(ch)
+	all, err := connections.GetConnections()
+	require.NoError(t, err)
+	c.(*mapCollector).observe(all["server1"], "ctf_2fort", time.Now().Add(time.Hour))
+
+	// the map state is kept when the server is renamed
+	renameFakeServer(t, "server1", "server1-renamed")
+	ch = make(chan prometheus.Metric, 10)
+	require.NoError(t, c.Update(context.Background(), ch))
+	assert.NoError(t, testutil.CollectAndCompare(drain(ch), strings.NewReader(`
+# HELP srcds_map_changes_total The map changes on the server seen by the exporter.
+# TYPE srcds_map_changes_total counter
+srcds_map_changes_total{server="server1-renamed"} 1
+`), "srcds_map_changes_total"))
+}
+
+func TestCollectorServerOverrides(t *testing.T) {
+	setupFakeServersWithOptions(t, map[string]map[string]string{
+		"server1": {"status": tf2Status},
+		"server2": {"status": tf2Status},
+	}, func(name string, opts *connector.ConnectionOptions) {
+		switch name {
+		case "server1":
+			opts.Labels = map[string]string{"region": "eu", "map": "ignored"}
+		case "server2":
+			opts.Collectors = []string{"players"}
+		}
+	})
+	collectAndCompare(t, NewMapCollector, `
+# HELP srcds_map_info The current map on the server, the series of the previous map is dropped on a map change.
+# TYPE srcds_map_info gauge
+srcds_map_info{map="pl_upward",region="eu",server="server1"} 1
+`, "srcds_map_info")
+}
+
+func TestPlayerCountCollector(t *testing.T) {
+	setupFakeServers(t, map[string]map[string]string{
+		"server1": {"status": tf2Status},
+	})
+	collectAndCompare(t, NewPlayerCountCollector, `
+# HELP srcds_playercount_bots The current count of bot players on the server.
+# TYPE srcds_playercount_bots gauge
+srcds_playercount_bots{server="server1"} 1
+# HELP srcds_playercount_current The current count players on the server.
+# TYPE srcds_playercount_current gauge
+srcds_playercount_current{server="server1"} 3
+# HELP srcds_playercount_humans The current count of humans players on the server.
+# TYPE srcds_playercount_humans gauge
+srcds_playercount_humans{server="server1"} 2
+# HELP srcds_playercount_limit The limit of players on the server.
+# TYPE srcds_playercount_limit gauge
+srcds_playercount_limit{server="server1"} 24
+`)
+}
+
+func TestPlayersCollector(t *testing.T) {
+	setupFakeServers(t, map[string]map[string]string{
+		"server1": {"status": tf2Status},
+	})
+	collectAndCompare(t, NewPlayersCollector, `
+# HELP srcds_player_joins_total The players who joined the server seen by the exporter.
+# TYPE srcds_player_joins_total counter
+srcds_player_joins_total{server="server1"} 0
+# HELP srcds_player_leaves_total The players who left the server seen by the exporter.
+# TYPE srcds_player_leaves_total counter
+srcds_player_leaves_total{server="server1"} 0
+# HELP srcds_player_session_duration_seconds The duration of the finished sessions of the players on the server.
+# TYPE srcds_player_session_duration_seconds histogram
+srcds_player_session_duration_seconds_bucket{server="server1",le="60"} 0
+srcds_player_session_duration_seconds_bucket{server="server1",le="300"} 0
+srcds_player_session_duration_seconds_bucket{server="server1",le="900"} 0
+srcds_player_session_duration_seconds_bucket{server="server1",le="1800"} 0
+srcds_player_session_duration_seconds_bucket{server="server1",le="3600"} 0
+srcds_player_session_duration_seconds_bucket{server="server1",le="7200"} 0
+srcds_player_session_duration_seconds_bucket{server="server1",le="14400"} 0
+srcds_player_session_duration_seconds_bucket{server="server1",le="28800"} 0
+srcds_player_session_duration_seconds_bucket{server="server1",le="+Inf"} 0
+srcds_player_session_duration_seconds_sum{server="server1"} 0
+srcds_player_session_duration_seconds_count{server="server1"} 0
+# HELP srcds_players_loss The current players loss on the server.
+# TYPE srcds_players_loss gauge
+srcds_players_loss{server="server1",steamid="[U:1:1015738]"} 0
+srcds_players_loss{server="server1",steamid="[U:1:1234567]"} 2
+# HELP srcds_players_online The current players on the server.
+# TYPE srcds_players_online gauge
+srcds_players_online{server="server1",steamid="[U:1:1015738]"} 1
+srcds_players_online{server="server1",steamid="[U:1:1234567]"} 1
+# HELP srcds_players_ping The current players ping on the server.
+# TYPE srcds_players_ping gauge
+srcds_players_ping{server="server1",steamid="[U:1:1015738]"} 65
+srcds_players_ping{server="server1",steamid="[U:1:1234567]"} 74
+`)
+}
+
+func TestPlayersCollectorRename(t *testing.T) {
+	setupFakeServers(t, map[string]map[string]string{
+		"server1": {"status": tf2Status},
+	})
+	c, err := NewPlayersCollector(nil)
+	require.NoError(t, err)
+	all, err := connections.GetConnections()
+	require.NoError(t, err)
+	c.(*playersCollector).sessions.observe(all["server1"], map[string]*models.Player{}, time.Unix(0, 0))
+
+	// the sessions are kept when the server is renamed, the players of the
+	// status joined
+	renameFakeServer(t, "server1", "server1-renamed")
+	ch := make(chan prometheus.Metric, 50)
+	require.NoError(t, c.Update(context.Background(), ch))
+	assert.NoError(t, testutil.CollectAndCompare(drain(ch), strings.NewReader(`
+# HELP srcds_player_joins_total The players who joined the server seen by the exporter.
+# TYPE srcds_player_joins_total counter
+srcds_player_joins_total{server="server1-renamed"} 2
+`), "srcds_player_joins_total"))
+}
+
+func TestInfoCollector(t *testing.T) {
+	setupFakeServersWithOptions(t, map[string]map[string]string{
+		"server1": {"status": tf2Status},
+	}, func(name string, opts *connector.ConnectionOptions) {
+		opts.Labels = map[string]string{"game": "Team Fortress"}
+	})
+	collectAndCompare(t, NewInfoCollector, `
+# HELP srcds_server_info Information about the server, the game is taken from A2S or the game label of the server.
+# TYPE srcds_server_info gauge
+srcds_server_info{game="Team Fortress",hostname="Test Server",secure="true",server="server1",steamid="85568392921274567",tags="payload",version="6300758/24"} 1
+# HELP srcds_server_version_changed_total The version changes of the server seen by the exporter.
+# TYPE srcds_server_version_changed_total counter
+srcds_server_version_changed_total{game="Team Fortress",server="server1"} 0
+`)
+}
+
+func TestInfoCollectorVersionChanges(t *testing.T) {
+	c, err := NewInfoCollector(nil)
+	require.NoError(t, err)
+	info := c.(*infoCollector)
+	server1, server2 := &connector.Connection{}, &connector.Connection{}
+	assert.Equal(t, 0, info.versionChanges(server1, "1"))
+	assert.Equal(t, 0, info.versionChanges(server1, "1"))
+	assert.Equal(t, 1, info.versionChanges(server1, "2"))
+	assert.Equal(t, 0, info.versionChanges(server2, "2"))
+	assert.Equal(t, 2, info.versionChanges(server1, "3"))
+}
+
+func TestInfoCollectorRename(t *testing.T) {
+	setupFakeServers(t, map[string]map[string]string{
+		"server1": {"status": tf2Status},
+	})
+	c, err := NewInfoCollector(nil)
+	require.NoError(t, err)
+	all, err := connections.GetConnections()
+	require.NoError(t, err)
+	info := c.(*infoCollector)
+	info.versionChanges(all["server1"], "6300757/24")
+
+	// the version is kept when the server is renamed, the update is a change
+	renameFakeServer(t, "server1", "server1-renamed")
+	ch := make(chan prometheus.Metric, 10)
+	require.NoError(t, c.Update(context.Background(), ch))
+	assert.NoError(t, testutil.CollectAndCompare(drain(ch), strings.NewReader(`
+# HELP srcds_server_version_changed_total The version changes of the server seen by the exporter.
+# TYPE srcds_server_version_changed_total counter
+srcds_server_version_changed_total{server="server1-renamed"} 1
+`), "srcds_server_version_changed_total"))
+}
+
+func TestStatsCollector(t *testing.T) {
+	// the status isn't queried for servers with only the stats collector
+	setupFakeServersWithOptions(t, map[string]map[string]string{
+		"server1": {"stats": tf2Stats},
+		"server2": {"stats": `  CPU   NetIn   NetOut    Uptime  Maps   FPS   Players  Svms    +-ms   ~tick
+  8.0    123.4   2345.6      115     3  128.00      10    0.28    0.05    0.02
+`},
+	}, func(name string, opts *connector.ConnectionOptions) {
+		opts.Collectors = []string{"stats"}
+	})
+	collectAndCompare(t, NewStatsCollector, `
+# HELP srcds_server_cpu_percent The current CPU usage of the server in percent.
+# TYPE srcds_server_cpu_percent gauge
+srcds_server_cpu_percent{server="server1"} 12.5
+srcds_server_cpu_percent{server="server2"} 8
+# HELP srcds_server_fps The current frames (ticks) per second of the server.
+# TYPE srcds_server_fps gauge
+srcds_server_fps{server="server1"} 66.67
+srcds_server_fps{server="server2"} 128
+# HELP srcds_server_map_changes_total The map changes since the server started.
+# TYPE srcds_server_map_changes_total counter
+srcds_server_map_changes_total{server="server1"} 17
+srcds_server_map_changes_total{server="server2"} 3
+# HELP srcds_server_net_in_kbps The current incoming traffic of the server in kB/s.
+# TYPE srcds_server_net_in_kbps gauge
+srcds_server_net_in_kbps{server="server1"} 3.21
+srcds_server_net_in_kbps{server="server2"} 123.4
+# HELP srcds_server_net_out_kbps The current outgoing traffic of the server in kB/s.
+# TYPE srcds_server_net_out_kbps gauge
+srcds_server_net_out_kbps{server="server1"} 45.67
+srcds_server_net_out_kbps{server="server2"} 2345.6
+# HELP srcds_server_uptime_seconds The uptime of the server in seconds (minute precision).
+# TYPE srcds_server_uptime_seconds gauge
+srcds_server_uptime_seconds{server="server1"} 74040
+srcds_server_uptime_seconds{server="server2"} 6900
+`)
+}
+
+func TestCollectorServerError(t *testing.T) {
+	setupFakeServers(t, map[string]map[string]string{
+		"server1": {},
+	})
+	c, err := NewMapCollector(nil)
+	require.NoError(t, err)
+	ch := make(chan prometheus.Metric, 10)
+	assert.Error(t, c.Update(context.Background(), ch))
+}
+
+func TestCollectorServerMetrics(t *testing.T) {
+	setupFakeServers(t, map[string]map[string]string{
+		"server1": {},
+		"server2": {"status": tf2Status},
+		"server3": {},
+	})
+	c, err := NewMapCollector(nil)
+	require.NoError(t, err)
+	ch := make(chan prometheus.Metric, 20)
+	err = c.Update(context.Background(), ch)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "2 of 3 servers failed")
+
+	// the failing servers don't stop the others
+	metrics := drainAll(ch)
+	assert.NoError(t, testutil.CollectAndCompare(metrics, strings.NewReader(`
+# HELP srcds_map_info The current map on the server, the series of the previous map is dropped on a map change.
+# TYPE srcds_map_info gauge
+srcds_map_info{map="pl_upward",server="server2"} 1
+# HELP srcds_scrape_collector_server_success srcds_exporter: Whether a collector succeeded per server.
+# TYPE srcds_scrape_collector_server_success gauge
+srcds_scrape_collector_server_success{collector="map",server="server1"} 0
+srcds_scrape_collector_server_success{collector="map",server="server2"} 1
+srcds_scrape_collector_server_success{collector="map",server="server3"} 0
+`), "srcds_map_info", "srcds_scrape_collector_server_success"))
+	durations := 0
+	for _, m := range metrics {
+		if m.Desc() == collectorServerDurationDesc {
+			durations++
+		}
+	}
+	assert.Equal(t, 3, durations)
+}
+
+func TestCollectorDeadline(t *testing.T) {
+	setupFakeServers(t, map[string]map[string]string{
+		"server1": {"status": tf2Status},
+		"server2": {"status": hangResponse},
+	})
+	c, err := NewMapCollector(nil)
+	require.NoError(t, err)
+
+	// the servers are collected in random order, server2 may use up the whole
+	// deadline before server1 so its response is cached before
+	connections, err := connections.GetConnections()
+	require.NoError(t, err)
+	_, err = connections["server1"].Get(context.Background(), "status")
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	ch := make(chan prometheus.Metric, 10)
+	err = c.Update(ctx, ch)
+	assert.True(t, errors.Is(err, context.DeadlineExceeded))
+	metrics := drain(ch)
+	assert.NoError(t, testutil.CollectAndCompare(metrics, strings.NewReader(`
+# HELP srcds_map_info The current map on the server, the series of the previous map is dropped on a map change.
+# TYPE srcds_map_info gauge
+srcds_map_info{map="pl_upward",server="server1"} 1
+`), "srcds_map_info"))
+}
+
+func TestCollectorsLint(t *testing.T) {
+	setupFakeServersWithOptions(t, map[string]map[string]string{
+		"server1": {"status": tf2Status, "stats": tf2Stats},
+		"server2": {"status": tf2Status, "stats": tf2Stats},
+	}, func(name string, opts *connector.ConnectionOptions) {
+		if name == "server1" {
+			opts.Labels = map[string]string{"region": "eu"}
+		}
+	})
+	reg := prometheus.NewPedanticRegistry()
+	for name, factory := range Factories {
+		// rank queries BattleMetrics
+		if name == "rank" {
+			continue
+		}
+		c, err := factory(serverLabelNames(t))
+		require.NoError(t, err, name)
+		tc := testCollector{c: c}
+		problems, err := testutil.CollectAndLint(tc)
+		require.NoError(t, err, name)
+		assert.Empty(t, problems, name)
+		reg.MustRegister(tc)
+	}
+	_, err := reg.Gather()
+	assert.NoError(t, err)
+}
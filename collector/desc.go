@@ -0,0 +1,89 @@
+/*
+Copyright 2020 Alexander Trost <galexrt@googlemail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package collector
+
+import (
+	"github.com/galexrt/srcds_exporter/connector"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Desc describes a metric exported by a collector
+type Desc struct {
+	Name string
+	Help string
+	// Type value type of the metric, unused for histograms
+	Type prometheus.ValueType
+	// Labels variable labels of the metric, the server label first
+	Labels []string
+
+	desc *prometheus.Desc
+	// extra additional labels of the servers, they can't override the labels
+	// of the collector
+	extra []string
+}
+
+// newDesc returns the Desc of a metric with the server label, the given
+// labels and the additional labels of the servers
+func newDesc(subsystem, name, help string, valueType prometheus.ValueType, extra []string, labels ...string) *Desc {
+	labels = append([]string{"server"}, labels...)
+	builtin := map[string]bool{}
+	for _, label := range labels {
+		builtin[label] = true
+	}
+	d := &Desc{
+		Name: prometheus.BuildFQName(Namespace, subsystem, name),
+		Help: help,
+		Type: valueType,
+	}
+	for _, label := range extra {
+		if !builtin[label] {
+			d.extra = append(d.extra, label)
+		}
+	}
+	d.Labels = append(labels, d.extra...)
+	d.desc = prometheus.NewDesc(d.Name, d.Help, d.Labels, nil)
+	return d
+}
+
+// Desc returns the prometheus.Desc of the metric
+func (d *Desc) Desc() *prometheus.Desc {
+	return d.desc
+}
+
+// metric returns the metric of the server, the values are the values of the
+// labels after the server label
+func (d *Desc) metric(con *connector.Connection, value float64, values ...string) prometheus.Metric {
+	return prometheus.MustNewConstMetric(d.desc, d.Type, value, d.labelValues(con, values)...)
+}
+
+// histogram returns the histogram of the server, the values are the values
+// of the labels after the server label
+func (d *Desc) histogram(con *connector.Connection, count uint64, sum float64, buckets map[float64]uint64, values ...string) prometheus.Metric {
+	return prometheus.MustNewConstHistogram(d.desc, count, sum, buckets, d.labelValues(con, values)...)
+}
+
+// labelValues returns the values of all labels of the metric of the server
+func (d *Desc) labelValues(con *connector.Connection, values []string) []string {
+	labels := con.Labels()
+	all := make([]string, 0, len(d.Labels))
+	all = append(all, con.Name())
+	all = append(all, values...)
+	for _, label := range d.extra {
+		all = append(all, labels[label])
+	}
+	return all
+}
@@ -0,0 +1,188 @@
+/*
+Copyright 2020 Alexander Trost <galexrt@googlemail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package collector
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/galexrt/srcds_exporter/connector"
+	"github.com/prometheus/client_golang/prometheus"
+	log "github.com/sirupsen/logrus"
+)
+
+// statusCollectors collectors which export the status of the servers
+var statusCollectors = []string{"info", "map", "playercount", "players"}
+
+// snapshotCollectors collectors which export the snapshots of the servers
+var snapshotCollectors = append([]string{"stats", "rules"}, statusCollectors...)
+
+var (
+	serverDurationDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(Namespace, "scrape", "server_duration_seconds"),
+		"srcds_exporter: Duration of querying a server in a scrape.",
+		[]string{"server"},
+		nil,
+	)
+	serverSuccessDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(Namespace, "scrape", "server_success"),
+		"srcds_exporter: Whether querying a server in a scrape succeeded.",
+		[]string{"server"},
+		nil,
+	)
+)
+
+// Engine queries the servers of a scrape concurrently before the collectors
+// run, a slow or dead server only delays the scrape by the server timeout
+type Engine struct {
+	concurrency int
+	timeout     time.Duration
+}
+
+// scrapeKey context key of the scrapeResult
+type scrapeKey struct{}
+
+// scrapeResult snapshots and errors of the servers queried for a scrape
+type scrapeResult struct {
+	snapshots map[*connector.Connection]*Snapshot
+	errs      map[*connector.Connection]error
+}
+
+// NewEngine returns a new Engine querying at most concurrency servers at
+// once, each server is given at most timeout (zero only limits the scrape)
+func NewEngine(concurrency int, timeout time.Duration) *Engine {
+	if concurrency < 1 {
+		concurrency = 1
+	}
+	return &Engine{
+		concurrency: concurrency,
+		timeout:     timeout,
+	}
+}
+
+// Describe implements the prometheus.Collector interface, it describes the
+// metrics per server of the engine and the collectors
+func (e *Engine) Describe(ch chan<- *prometheus.Desc) {
+	ch <- serverDurationDesc
+	ch <- serverSuccessDesc
+	ch <- collectorServerDurationDesc
+	ch <- collectorServerSuccessDesc
+}
+
+// Scrape queries the servers and returns the context the collectors of the
+// scrape are run with, they are served the results of these queries
+func (e *Engine) Scrape(ctx context.Context, ch chan<- prometheus.Metric) context.Context {
+	result := &scrapeResult{
+		snapshots: map[*connector.Connection]*Snapshot{},
+		errs:      map[*connector.Connection]error{},
+	}
+	var (
+		mu  sync.Mutex
+		wg  sync.WaitGroup
+		sem = make(chan struct{}, e.concurrency)
+	)
+	for _, con := range getSnapshotConnections() {
+		wg.Add(1)
+		go func(con *connector.Connection) {
+			defer wg.Done()
+			begin := time.Now()
+			snapshot, err := e.query(ctx, con, sem)
+			duration := time.Since(begin)
+
+			var success float64
+			if err != nil {
+				log.Errorf("ERROR: server %s failed after %fs: %s", con.Name(), duration.Seconds(), err)
+			} else if snapshot.StatsErr != nil {
+				log.Errorf("ERROR: stats of server %s failed after %fs: %s", con.Name(), duration.Seconds(), snapshot.StatsErr)
+			} else {
+				log.Debugf("OK: server %s succeeded after %fs.", con.Name(), duration.Seconds())
+				success = 1
+			}
+			ch <- prometheus.MustNewConstMetric(serverDurationDesc, prometheus.GaugeValue, duration.Seconds(), con.Name())
+			ch <- prometheus.MustNewConstMetric(serverSuccessDesc, prometheus.GaugeValue, success, con.Name())
+
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil {
+				result.errs[con] = err
+			} else {
+				result.snapshots[con] = snapshot
+			}
+		}(con)
+	}
+	wg.Wait()
+	return context.WithValue(ctx, scrapeKey{}, result)
+}
+
+// query waits for a worker and gets the snapshot of the server within the
+// server timeout
+func (e *Engine) query(ctx context.Context, con *connector.Connection, sem chan struct{}) (*Snapshot, error) {
+	select {
+	case sem <- struct{}{}:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	defer func() { <-sem }()
+	if e.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, e.timeout)
+		defer cancel()
+	}
+	return getSnapshot(ctx, con)
+}
+
+// scrapedSnapshot returns the snapshot or error of the server queried for the
+// scrape of ctx, ok is false if the server wasn't queried
+func scrapedSnapshot(ctx context.Context, con *connector.Connection) (snapshot *Snapshot, ok bool, err error) {
+	result, found := ctx.Value(scrapeKey{}).(*scrapeResult)
+	if !found {
+		return nil, false, nil
+	}
+	if err, ok := result.errs[con]; ok {
+		return nil, true, err
+	}
+	snapshot, ok = result.snapshots[con]
+	return snapshot, ok, nil
+}
+
+// getSnapshotConnections returns the connections of the servers at least one
+// collector exporting snapshots is enabled for
+func getSnapshotConnections() []*connector.Connection {
+	all, err := connections.GetConnections()
+	if err != nil {
+		log.Fatal(err)
+	}
+	var result []*connector.Connection
+	for _, con := range all {
+		if collectorsEnabled(con, snapshotCollectors) {
+			result = append(result, con)
+		}
+	}
+	return result
+}
+
+// collectorsEnabled returns whether at least one of the collectors is enabled
+// for the server
+func collectorsEnabled(con *connector.Connection, collectors []string) bool {
+	for _, name := range collectors {
+		if con.CollectorEnabled(name) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,167 @@
+/*
+Copyright 2020 Alexander Trost <galexrt@googlemail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package collector
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// metricsCollector collects the given metrics
+type metricsCollector []prometheus.Metric
+
+func (c metricsCollector) Describe(ch chan<- *prometheus.Desc) {
+	prometheus.DescribeByCollect(c, ch)
+}
+
+func (c metricsCollector) Collect(ch chan<- prometheus.Metric) {
+	for _, m := range c {
+		ch <- m
+	}
+}
+
+// drain returns the metrics sent to ch without the metrics per server of the
+// collectors, ch is closed
+func drain(ch chan prometheus.Metric) metricsCollector {
+	close(ch)
+	var metrics metricsCollector
+	for m := range ch {
+		if !isCollectorServerMetric(m) {
+			metrics = append(metrics, m)
+		}
+	}
+	return metrics
+}
+
+// drainAll returns all metrics sent to ch, ch is closed
+func drainAll(ch chan prometheus.Metric) metricsCollector {
+	close(ch)
+	var metrics metricsCollector
+	for m := range ch {
+		metrics = append(metrics, m)
+	}
+	return metrics
+}
+
+func isCollectorServerMetric(m prometheus.Metric) bool {
+	return m.Desc() == collectorServerDurationDesc || m.Desc() == collectorServerSuccessDesc
+}
+
+func TestEngine(t *testing.T) {
+	setupFakeServers(t, map[string]map[string]string{
+		"server1": {"status": tf2Status},
+		"server2": {"status": hangResponse},
+		"server3": {"status": tf2Status},
+	})
+	const timeout = 50 * time.Millisecond
+	e := NewEngine(1, timeout)
+
+	ch := make(chan prometheus.Metric, 10)
+	begin := time.Now()
+	ctx := e.Scrape(context.Background(), ch)
+	assert.Less(t, int64(time.Since(begin)), int64(2*time.Second), "the dead server stalled the scrape")
+	assert.NoError(t, testutil.CollectAndCompare(drain(ch), strings.NewReader(`
+# HELP srcds_scrape_server_success srcds_exporter: Whether querying a server in a scrape succeeded.
+# TYPE srcds_scrape_server_success gauge
+srcds_scrape_server_success{server="server1"} 1
+srcds_scrape_server_success{server="server2"} 0
+srcds_scrape_server_success{server="server3"} 1
+`), "srcds_scrape_server_success"))
+
+	// the collectors are served the queried snapshots and skip the dead server
+	c, err := NewMapCollector(nil)
+	require.NoError(t, err)
+	ch = make(chan prometheus.Metric, 20)
+	err = c.Update(ctx, ch)
+	assert.True(t, errors.Is(err, context.DeadlineExceeded))
+	assert.NoError(t, testutil.CollectAndCompare(drain(ch), strings.NewReader(`
+# HELP srcds_map_info The current map on the server, the series of the previous map is dropped on a map change.
+# TYPE srcds_map_info gauge
+srcds_map_info{map="pl_upward",server="server1"} 1
+srcds_map_info{map="pl_upward",server="server3"} 1
+`), "srcds_map_info"))
+}
+
+func TestEngineStats(t *testing.T) {
+	setupFakeServers(t, map[string]map[string]string{
+		"server1": {"status": tf2Status, "stats": tf2Stats},
+		"server2": {"status": tf2Status, "stats": hangResponse},
+	})
+	const timeout = 50 * time.Millisecond
+	e := NewEngine(2, timeout)
+
+	ch := make(chan prometheus.Metric, 10)
+	begin := time.Now()
+	ctx := e.Scrape(context.Background(), ch)
+	assert.Less(t, int64(time.Since(begin)), int64(2*time.Second), "the hanging stats stalled the scrape")
+	assert.NoError(t, testutil.CollectAndCompare(drain(ch), strings.NewReader(`
+# HELP srcds_scrape_server_success srcds_exporter: Whether querying a server in a scrape succeeded.
+# TYPE srcds_scrape_server_success gauge
+srcds_scrape_server_success{server="server1"} 1
+srcds_scrape_server_success{server="server2"} 0
+`), "srcds_scrape_server_success"))
+
+	// the status of server2 is served although its stats timed out
+	stats, err := NewStatsCollector(nil)
+	require.NoError(t, err)
+	ch = make(chan prometheus.Metric, 20)
+	err = stats.Update(ctx, ch)
+	assert.True(t, errors.Is(err, context.DeadlineExceeded), "%v", err)
+	assert.NoError(t, testutil.CollectAndCompare(drain(ch), strings.NewReader(`
+# HELP srcds_server_fps The current frames (ticks) per second of the server.
+# TYPE srcds_server_fps gauge
+srcds_server_fps{server="server1"} 66.67
+`), "srcds_server_fps"))
+	m, err := NewMapCollector(nil)
+	require.NoError(t, err)
+	ch = make(chan prometheus.Metric, 20)
+	require.NoError(t, m.Update(ctx, ch))
+}
+
+func TestEngineConcurrency(t *testing.T) {
+	setupFakeServers(t, map[string]map[string]string{
+		"server1": {"status": hangResponse},
+		"server2": {"status": hangResponse},
+	})
+	const timeout = 50 * time.Millisecond
+
+	// the dead servers are queried one after the other
+	begin := time.Now()
+	NewEngine(1, timeout).Scrape(context.Background(), make(chan prometheus.Metric, 10))
+	assert.GreaterOrEqual(t, int64(time.Since(begin)), int64(2*timeout))
+
+	// the scrape deadline limits servers waiting for a worker
+	setupFakeServers(t, map[string]map[string]string{
+		"server3": {"status": hangResponse},
+		"server4": {"status": hangResponse},
+	})
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	begin = time.Now()
+	ch := make(chan prometheus.Metric, 10)
+	NewEngine(1, time.Minute).Scrape(ctx, ch)
+	assert.Less(t, int64(time.Since(begin)), int64(time.Second))
+	assert.Equal(t, 4, testutil.CollectAndCount(drain(ch)))
+}
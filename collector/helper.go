@@ -17,14 +17,105 @@ limitations under the License.
 package collector
 
 import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
 	"github.com/galexrt/srcds_exporter/connector"
+	"github.com/prometheus/client_golang/prometheus"
 	log "github.com/sirupsen/logrus"
 )
 
-func getConnections() map[string]*connector.Connection {
-	connections, err := connections.GetConnections()
+var (
+	collectorServerDurationDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(Namespace, "scrape", "collector_server_duration_seconds"),
+		"srcds_exporter: Duration of a collector scrape per server.",
+		[]string{"collector", "server"},
+		nil,
+	)
+	collectorServerSuccessDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(Namespace, "scrape", "collector_server_success"),
+		"srcds_exporter: Whether a collector succeeded per server.",
+		[]string{"collector", "server"},
+		nil,
+	)
+)
+
+// getConnections returns the connections of the servers the collector is
+// enabled for
+func getConnections(collector string) map[string]*connector.Connection {
+	all, err := connections.GetConnections()
 	if err != nil {
 		log.Fatal(err)
 	}
-	return connections
+	enabled := make(map[string]*connector.Connection, len(all))
+	for name, con := range all {
+		if con.CollectorEnabled(collector) {
+			enabled[name] = con
+		}
+	}
+	return enabled
+}
+
+// activeConnections returns the set of the connections, state kept per
+// connection is dropped for connections which aren't in the set anymore
+func activeConnections(servers map[string]*connector.Connection) map[*connector.Connection]bool {
+	active := make(map[*connector.Connection]bool, len(servers))
+	for _, con := range servers {
+		active[con] = true
+	}
+	return active
+}
+
+// collectServers runs fn for the servers in order of their names and exports
+// the duration and success per server. Failing servers don't stop the others,
+// the first error is returned with the number of failed servers.
+func collectServers(collector string, servers map[string]*connector.Connection, ch chan<- prometheus.Metric, fn func(con *connector.Connection) error) error {
+	names := make([]string, 0, len(servers))
+	for name := range servers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var (
+		failed   int
+		firstErr error
+	)
+	for _, name := range names {
+		con := servers[name]
+		begin := time.Now()
+		err := fn(con)
+		duration := time.Since(begin)
+		var success float64
+		if err != nil {
+			log.Debugf("%s collector failed for server %s: %s", collector, name, err)
+			failed++
+			if firstErr == nil {
+				firstErr = err
+			}
+		} else {
+			success = 1
+		}
+		ch <- prometheus.MustNewConstMetric(collectorServerDurationDesc, prometheus.GaugeValue, duration.Seconds(), collector, name)
+		ch <- prometheus.MustNewConstMetric(collectorServerSuccessDesc, prometheus.GaugeValue, success, collector, name)
+	}
+	if firstErr != nil {
+		return fmt.Errorf("%d of %d servers failed: %w", failed, len(servers), firstErr)
+	}
+	return nil
+}
+
+// getSnapshot returns the snapshot queried for the scrape, the latest
+// snapshot of polled servers or queries the server
+func getSnapshot(ctx context.Context, con *connector.Connection) (*Snapshot, error) {
+	if snapshot, ok, err := scrapedSnapshot(ctx, con); ok {
+		return snapshot, err
+	}
+	if poller != nil {
+		if snapshot, ok, err := poller.snapshot(con); ok {
+			return snapshot, err
+		}
+	}
+	return fetchSnapshot(ctx, con)
 }
@@ -0,0 +1,114 @@
+/*
+Copyright 2020 Alexander Trost <galexrt@googlemail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package collector
+
+import (
+	"context"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/galexrt/srcds_exporter/connector"
+	"github.com/galexrt/srcds_exporter/parser"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+type infoCollector struct {
+	info           *Desc
+	versionChanged *Desc
+
+	// versions last seen version and the number of version changes per
+	// server, keyed by connection so they're kept when the server is renamed
+	mu       sync.Mutex
+	versions map[*connector.Connection]*serverVersion
+}
+
+// serverVersion last seen version of a server
+type serverVersion struct {
+	version string
+	changes int
+}
+
+func init() {
+	Factories["info"] = NewInfoCollector
+}
+
+// NewInfoCollector returns a new Collector exposing the hostname, version,
+// tags and SteamID of the server.
+func NewInfoCollector(labels []string) (Collector, error) {
+	return &infoCollector{
+		info: newDesc("server", "info", "Information about the server, the game is taken from A2S or the game label of the server.",
+			prometheus.GaugeValue, labels, "hostname", "version", "secure", "game", "steamid", "tags"),
+		versionChanged: newDesc("server", "version_changed_total", "The version changes of the server seen by the exporter.",
+			prometheus.CounterValue, labels),
+		versions: map[*connector.Connection]*serverVersion{},
+	}, nil
+}
+
+func (c *infoCollector) Descs() []*Desc {
+	return []*Desc{c.info, c.versionChanged}
+}
+
+func (c *infoCollector) Update(ctx context.Context, ch chan<- prometheus.Metric) error {
+	servers := getConnections("info")
+	c.mu.Lock()
+	active := activeConnections(servers)
+	for con := range c.versions {
+		if !active[con] {
+			delete(c.versions, con)
+		}
+	}
+	c.mu.Unlock()
+	return collectServers("info", servers, ch, func(con *connector.Connection) error {
+		snapshot, err := getSnapshot(ctx, con)
+		if err != nil {
+			return err
+		}
+		game := snapshot.Game
+		if game == "" {
+			game = con.Labels()["game"]
+		}
+		steamID := snapshot.SteamID64
+		if steamID == "" {
+			steamID = snapshot.SteamID
+		}
+		// the version line also lists the build number and flags, e.g.,
+		// "secure", which change without a new version
+		version := parser.VersionToken(snapshot.Version)
+		ch <- c.info.metric(con, 1, snapshot.Hostname, version,
+			strconv.FormatBool(snapshot.Secure), game, steamID, strings.Join(snapshot.Tags, ","))
+		ch <- c.versionChanged.metric(con, float64(c.versionChanges(con, version)))
+		return nil
+	})
+}
+
+// versionChanges records the version of the server and returns the number of
+// times it changed
+func (c *infoCollector) versionChanges(con *connector.Connection, version string) int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	v, ok := c.versions[con]
+	if !ok {
+		c.versions[con] = &serverVersion{version: version}
+		return 0
+	}
+	if v.version != version {
+		v.version = version
+		v.changes++
+	}
+	return v.changes
+}
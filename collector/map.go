@@ -17,56 +17,121 @@ limitations under the License.
 package collector
 
 import (
-	"github.com/galexrt/srcds_exporter/parser"
+	"context"
+	"sync"
+	"time"
+
+	"github.com/galexrt/srcds_exporter/connector"
+
 	"github.com/prometheus/client_golang/prometheus"
 )
 
 type mapCollector struct {
-	current []*prometheus.Desc
+	info     *Desc
+	changes  *Desc
+	start    *Desc
+	playtime *Desc
+
+	// maps map state per server, keyed by connection so it's kept when the
+	// server is renamed
+	mu   sync.Mutex
+	maps map[*connector.Connection]*mapState
+}
+
+// mapState current map of a server and its history as seen by the exporter
+type mapState struct {
+	current string
+	// start time the current map was first seen, seen time it was last seen
+	start   time.Time
+	seen    time.Time
+	changes int
+	// playtime cumulative time per map
+	playtime map[string]time.Duration
 }
 
 func init() {
 	Factories["map"] = NewMapCollector
 }
 
-// NewMapCollector returns a new Collector exposing the current map.
-func NewMapCollector() (Collector, error) {
-	current := []*prometheus.Desc{}
-	for _, con := range getConnections() {
-		resp, err := con.Get("status")
-		if err != nil {
-			return nil, err
-		}
-		mapName := parser.ParseMap(resp)
-		current = append(current, prometheus.NewDesc(
-			prometheus.BuildFQName(Namespace, "", "map"),
-			"The current map on the server.",
-			nil, prometheus.Labels{
-				"server": con.Name,
-				"map":    mapName,
-			}))
-	}
+// NewMapCollector returns a new Collector exposing the current map and the
+// map changes.
+func NewMapCollector(labels []string) (Collector, error) {
 	return &mapCollector{
-		current: current,
+		info: newDesc("map", "info", "The current map on the server, the series of the previous map is dropped on a map change.",
+			prometheus.GaugeValue, labels, "map"),
+		changes: newDesc("map", "changes_total", "The map changes on the server seen by the exporter.",
+			prometheus.CounterValue, labels),
+		start: newDesc("map", "start_timestamp_seconds", "The time the current map was first seen by the exporter in unix time.",
+			prometheus.GaugeValue, labels),
+		playtime: newDesc("map", "playtime_seconds_total", "The cumulative time the maps have been played on the server seen by the exporter.",
+			prometheus.CounterValue, labels, "map"),
+		maps: map[*connector.Connection]*mapState{},
 	}, nil
 }
 
-func (c *mapCollector) Update(ch chan<- prometheus.Metric) error {
-	for _, con := range getConnections() {
-		resp, err := con.Get("status")
+func (c *mapCollector) Descs() []*Desc {
+	return []*Desc{c.info, c.changes, c.start, c.playtime}
+}
+
+func (c *mapCollector) Update(ctx context.Context, ch chan<- prometheus.Metric) error {
+	servers := getConnections("map")
+	c.mu.Lock()
+	active := activeConnections(servers)
+	for con := range c.maps {
+		if !active[con] {
+			delete(c.maps, con)
+		}
+	}
+	c.mu.Unlock()
+	return collectServers("map", servers, ch, func(con *connector.Connection) error {
+		snapshot, err := getSnapshot(ctx, con)
 		if err != nil {
 			return err
 		}
-		mapName := parser.ParseMap(resp)
-		current := prometheus.NewDesc(
-			prometheus.BuildFQName(Namespace, "", "map"),
-			"The current map on the server.",
-			nil, prometheus.Labels{
-				"server": con.Name,
-				"map":    mapName,
-			})
-		ch <- prometheus.MustNewConstMetric(
-			current, prometheus.GaugeValue, float64(1))
+		state := c.observe(con, snapshot.Map, snapshot.Time)
+		ch <- c.info.metric(con, 1, state.current)
+		ch <- c.changes.metric(con, float64(state.changes))
+		ch <- c.start.metric(con, float64(state.start.UnixNano())/1e9)
+		for name, playtime := range state.playtime {
+			ch <- c.playtime.metric(con, playtime.Seconds(), name)
+		}
+		return nil
+	})
+}
+
+// observe records the map of the server seen at t and returns a copy of the
+// map state of the server
+func (c *mapCollector) observe(con *connector.Connection, current string, t time.Time) mapState {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	state, ok := c.maps[con]
+	if !ok {
+		state = &mapState{
+			current:  current,
+			start:    t,
+			seen:     t,
+			playtime: map[string]time.Duration{current: 0},
+		}
+		c.maps[con] = state
+	}
+	// snapshots served from the cache can be older than the last one seen
+	if t.After(state.seen) {
+		if state.current == current {
+			state.playtime[current] += t.Sub(state.seen)
+		} else {
+			state.current = current
+			state.start = t
+			state.changes++
+			if _, ok := state.playtime[current]; !ok {
+				state.playtime[current] = 0
+			}
+		}
+		state.seen = t
+	}
+	result := *state
+	result.playtime = make(map[string]time.Duration, len(state.playtime))
+	for name, playtime := range state.playtime {
+		result.playtime[name] = playtime
 	}
-	return nil
+	return result
 }
@@ -17,92 +17,58 @@ limitations under the License.
 package collector
 
 import (
-	"github.com/galexrt/srcds_exporter/parser"
+	"context"
+
+	"github.com/galexrt/srcds_exporter/connector"
+
 	"github.com/prometheus/client_golang/prometheus"
 )
 
 type playerCountCollector struct {
-	current []*prometheus.Desc
-	limit   []*prometheus.Desc
+	current *Desc
+	limit   *Desc
+	humans  *Desc
+	bots    *Desc
 }
 
 func init() {
 	Factories["playercount"] = NewPlayerCountCollector
 }
 
-// NewPlayerCountCollector returns a new Collector exposing the current map.
-func NewPlayerCountCollector() (Collector, error) {
-	current := []*prometheus.Desc{}
-	limit := []*prometheus.Desc{}
-	for _, con := range getConnections() {
-		current = append(current, prometheus.NewDesc(
-			prometheus.BuildFQName(Namespace, "playercount", "current"),
-			"The current player count of the server.",
-			nil, prometheus.Labels{
-				"server": con.Name,
-			}))
-		limit = append(limit, prometheus.NewDesc(
-			prometheus.BuildFQName(Namespace, "playercount", "limit"),
-			"The current player count of the server.",
-			nil, prometheus.Labels{
-				"server": con.Name,
-			}))
-	}
+// NewPlayerCountCollector returns a new Collector exposing the current player
+// count.
+func NewPlayerCountCollector(labels []string) (Collector, error) {
 	return &playerCountCollector{
-		current: current,
-		limit:   limit,
+		current: newDesc("playercount", "current", "The current count players on the server.",
+			prometheus.GaugeValue, labels),
+		limit: newDesc("playercount", "limit", "The limit of players on the server.",
+			prometheus.GaugeValue, labels),
+		humans: newDesc("playercount", "humans", "The current count of humans players on the server.",
+			prometheus.GaugeValue, labels),
+		bots: newDesc("playercount", "bots", "The current count of bot players on the server.",
+			prometheus.GaugeValue, labels),
 	}, nil
 }
 
-func (c *playerCountCollector) Update(ch chan<- prometheus.Metric) error {
-	for _, con := range getConnections() {
-		resp, err := con.Get("status")
-		if err != nil {
-			return err
-		}
-		playerCount, err := parser.ParsePlayerCount(resp)
+func (c *playerCountCollector) Descs() []*Desc {
+	return []*Desc{c.current, c.limit, c.humans, c.bots}
+}
+
+func (c *playerCountCollector) Update(ctx context.Context, ch chan<- prometheus.Metric) error {
+	return collectServers("playercount", getConnections("playercount"), ch, func(con *connector.Connection) error {
+		snapshot, err := getSnapshot(ctx, con)
 		if err != nil {
 			return err
 		}
-
-		current := prometheus.NewDesc(
-			prometheus.BuildFQName(Namespace, "playercount", "current"),
-			"The current count players on the server.",
-			nil, prometheus.Labels{
-				"server": con.Name,
-			})
-		limit := prometheus.NewDesc(
-			prometheus.BuildFQName(Namespace, "playercount", "limit"),
-			"The limit of players on the server.",
-			nil, prometheus.Labels{
-				"server": con.Name,
-			})
-		ch <- prometheus.MustNewConstMetric(
-			current, prometheus.GaugeValue, float64(playerCount.Current))
-		ch <- prometheus.MustNewConstMetric(
-			limit, prometheus.GaugeValue, float64(playerCount.Max))
-
+		playerCount := snapshot.PlayerCount
+		ch <- c.current.metric(con, float64(playerCount.Current))
+		ch <- c.limit.metric(con, float64(playerCount.Max))
 		if playerCount.Humans != -1 {
-			humans := prometheus.NewDesc(
-				prometheus.BuildFQName(Namespace, "playercount", "humans"),
-				"The current count of humans players on the server.",
-				nil, prometheus.Labels{
-					"server": con.Name,
-				})
-			ch <- prometheus.MustNewConstMetric(
-				humans, prometheus.GaugeValue, float64(playerCount.Humans))
+			ch <- c.humans.metric(con, float64(playerCount.Humans))
 		}
-
 		if playerCount.Bots != -1 {
-			bots := prometheus.NewDesc(
-				prometheus.BuildFQName(Namespace, "playercount", "bots"),
-				"The current count of bot players on the server.",
-				nil, prometheus.Labels{
-					"server": con.Name,
-				})
-			ch <- prometheus.MustNewConstMetric(
-				bots, prometheus.GaugeValue, float64(playerCount.Bots))
+			ch <- c.bots.metric(con, float64(playerCount.Bots))
 		}
-	}
-	return nil
+		return nil
+	})
 }
@@ -17,119 +17,72 @@ limitations under the License.
 package collector
 
 import (
-	"github.com/galexrt/srcds_exporter/parser"
+	"context"
+
+	"github.com/galexrt/srcds_exporter/connector"
 	"github.com/prometheus/client_golang/prometheus"
 )
 
-/*var (
-	currentPlayers     = map[string]map[string]struct{}{}
-	playersToBeRemoved = map[string]map[string]struct{}{}
-)*/
-
 type playersCollector struct {
-	list []*prometheus.Desc
-	ping []*prometheus.Desc
-	loss []*prometheus.Desc
+	list            *Desc
+	ping            *Desc
+	loss            *Desc
+	joins           *Desc
+	leaves          *Desc
+	sessionDuration *Desc
+
+	sessions *playerSessions
 }
 
 func init() {
 	Factories["players"] = NewPlayersCollector
 }
 
-// NewPlayersCollector returns a new Collector exposing the current players.
-func NewPlayersCollector() (Collector, error) {
-	list := []*prometheus.Desc{}
-	ping := []*prometheus.Desc{}
-	loss := []*prometheus.Desc{}
-	for _, con := range getConnections() {
-		/*currentPlayers[con.Name] = map[string]struct{}{}
-		playersToBeRemoved[con.Name] = map[string]struct{}{}*/
-		list = append(list, prometheus.NewDesc(
-			prometheus.BuildFQName(Namespace, "players", "online"),
-			"The current players on the server.",
-			nil, prometheus.Labels{
-				"server": con.Name,
-			}))
-		ping = append(ping, prometheus.NewDesc(
-			prometheus.BuildFQName(Namespace, "players", "ping"),
-			"The current players ping on the server.",
-			nil, prometheus.Labels{
-				"server": con.Name,
-			}))
-		loss = append(loss, prometheus.NewDesc(
-			prometheus.BuildFQName(Namespace, "players", "loss"),
-			"The current players loss on the server.",
-			nil, prometheus.Labels{
-				"server": con.Name,
-			}))
-		/*resp, _ := con.Get("status")
-		players := parser.ParsePlayers(resp)
-		for steamID := range players {
-			currentPlayers[con.Name][steamID] = struct{}{}
-		}*/
-	}
+// NewPlayersCollector returns a new Collector exposing the current players and
+// their sessions.
+func NewPlayersCollector(labels []string) (Collector, error) {
 	return &playersCollector{
-		list: list,
-		ping: ping,
-		loss: loss,
+		list: newDesc("players", "online", "The current players on the server.",
+			prometheus.GaugeValue, labels, "steamid"),
+		ping: newDesc("players", "ping", "The current players ping on the server.",
+			prometheus.GaugeValue, labels, "steamid"),
+		loss: newDesc("players", "loss", "The current players loss on the server.",
+			prometheus.GaugeValue, labels, "steamid"),
+		joins: newDesc("player", "joins_total", "The players who joined the server seen by the exporter.",
+			prometheus.CounterValue, labels),
+		leaves: newDesc("player", "leaves_total", "The players who left the server seen by the exporter.",
+			prometheus.CounterValue, labels),
+		sessionDuration: newDesc("player", "session_duration_seconds", "The duration of the finished sessions of the players on the server.",
+			prometheus.UntypedValue, labels),
+		sessions: newPlayerSessions(),
 	}, nil
 }
 
-func (c *playersCollector) Update(ch chan<- prometheus.Metric) error {
-	for _, con := range getConnections() {
-		resp, err := con.Get("status")
-		if err != nil {
-			return err
-		}
-		players, err := parser.ParsePlayers(resp)
+func (c *playersCollector) Descs() []*Desc {
+	return []*Desc{c.list, c.ping, c.loss, c.joins, c.leaves, c.sessionDuration}
+}
+
+func (c *playersCollector) Update(ctx context.Context, ch chan<- prometheus.Metric) error {
+	servers := getConnections("players")
+	c.sessions.prune(servers)
+	return collectServers("players", servers, ch, func(con *connector.Connection) error {
+		snapshot, err := getSnapshot(ctx, con)
 		if err != nil {
 			return err
 		}
-		//var value = 1
-		for _, player := range players {
-			/*if _, ok := playersToBeRemoved[con.Name][player.SteamID]; ok {
-				fmt.Println("LINE: 51")
-				delete(playersToBeRemoved[con.Name], player.SteamID)
-				currentPlayers[con.Name][player.SteamID] = struct{}{}
-			} else {
-				if _, ok := currentPlayers[con.Name][player.SteamID]; !ok {
-					fmt.Println("LINE: 56")
-					currentPlayers[con.Name][player.SteamID] = struct{}{}
-				} else {
-					fmt.Println("LINE: 59")
-					value = 0
-					delete(currentPlayers[con.Name], player.SteamID)
-					playersToBeRemoved[con.Name][player.SteamID] = struct{}{}
-				}
-			}*/
-			list := prometheus.NewDesc(
-				prometheus.BuildFQName(Namespace, "players", "online"),
-				"The current players on the server.",
-				nil, prometheus.Labels{
-					"server":  con.Name,
-					"steamid": player.SteamID,
-				})
-			ping := prometheus.NewDesc(
-				prometheus.BuildFQName(Namespace, "players", "ping"),
-				"The current players ping on the server.",
-				nil, prometheus.Labels{
-					"server":  con.Name,
-					"steamid": player.SteamID,
-				})
-			loss := prometheus.NewDesc(
-				prometheus.BuildFQName(Namespace, "players", "loss"),
-				"The current players loss on the server.",
-				nil, prometheus.Labels{
-					"server":  con.Name,
-					"steamid": player.SteamID,
-				})
-			ch <- prometheus.MustNewConstMetric(
-				list, prometheus.GaugeValue, float64(1))
-			ch <- prometheus.MustNewConstMetric(
-				ping, prometheus.GaugeValue, float64(player.Ping))
-			ch <- prometheus.MustNewConstMetric(
-				loss, prometheus.GaugeValue, float64(player.Loss))
+		for _, player := range snapshot.Players {
+			// CS2 and A2S don't list SteamIDs of the players
+			if player.SteamID == "" {
+				continue
+			}
+			ch <- c.list.metric(con, 1, player.SteamID)
+			ch <- c.ping.metric(con, float64(player.Ping), player.SteamID)
+			ch <- c.loss.metric(con, float64(player.Loss), player.SteamID)
 		}
-	}
-	return nil
+		stats := c.sessions.observe(con, snapshot.Players, snapshot.Time)
+		ch <- c.joins.metric(con, float64(stats.joins))
+		ch <- c.leaves.metric(con, float64(stats.leaves))
+		ch <- c.sessionDuration.histogram(con, stats.count, stats.sum, stats.buckets)
+		return nil
+	})
 }
@@ -0,0 +1,289 @@
+/*
+Copyright 2020 Alexander Trost <galexrt@googlemail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package collector
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/galexrt/srcds_exporter/connector"
+	"github.com/galexrt/srcds_exporter/parser"
+	"github.com/galexrt/srcds_exporter/parser/models"
+	"github.com/prometheus/client_golang/prometheus"
+	log "github.com/sirupsen/logrus"
+)
+
+var snapshotAgeDesc = prometheus.NewDesc(
+	prometheus.BuildFQName(Namespace, "snapshot", "age_seconds"),
+	"srcds_exporter: Age of the latest snapshot of a polled server.",
+	[]string{"server"},
+	nil,
+)
+
+// Snapshot parsed status of a server at a point in time, the Players are nil
+// if the protocol doesn't list players
+type Snapshot struct {
+	Time time.Time
+	models.Status
+	// Stats performance stats of the server, nil if the stats collector is
+	// disabled for the server or the protocol has no stats command
+	Stats *models.Stats
+	// StatsErr error of fetching the Stats, the Status is valid nevertheless
+	StatsErr error
+	// Rules A2S_RULES of the server, nil if the rules collector is disabled
+	// for the server or the protocol isn't A2S
+	Rules map[string]string
+	// RulesErr error of fetching the Rules, the Status is valid nevertheless
+	RulesErr error
+}
+
+// Poller polls the servers with a poll interval in the background, the
+// collectors serve these servers from the latest snapshot instead of querying
+// them on every scrape
+type Poller struct {
+	mu      sync.RWMutex
+	servers map[*connector.Connection]*polledServer
+}
+
+// polledServer snapshot and error of the latest poll, both are guarded by the
+// Poller mutex
+type polledServer struct {
+	cancel   context.CancelFunc
+	snapshot *Snapshot
+	err      error
+}
+
+// NewPoller returns a new Poller, it polls no servers until Sync is called
+func NewPoller() *Poller {
+	return &Poller{
+		servers: map[*connector.Connection]*polledServer{},
+	}
+}
+
+// Sync starts polling the connections with a poll interval and stops polling
+// connections which have been removed
+func (p *Poller) Sync(connections map[string]*connector.Connection) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	active := map[*connector.Connection]bool{}
+	for _, con := range connections {
+		if interval, _ := con.PollInterval(); interval <= 0 {
+			continue
+		}
+		active[con] = true
+		if _, ok := p.servers[con]; ok {
+			continue
+		}
+		ctx, cancel := context.WithCancel(context.Background())
+		server := &polledServer{cancel: cancel}
+		p.servers[con] = server
+		go p.poll(ctx, con, server)
+	}
+	for con, server := range p.servers {
+		if !active[con] {
+			server.cancel()
+			delete(p.servers, con)
+		}
+	}
+}
+
+// Stop stops polling all servers
+func (p *Poller) Stop() {
+	p.Sync(nil)
+}
+
+// poll takes a snapshot of the server every poll interval until ctx is done,
+// the first snapshot is taken after a random delay of up to the jitter so the
+// servers aren't polled all at once
+func (p *Poller) poll(ctx context.Context, con *connector.Connection, server *polledServer) {
+	interval, jitter := con.PollInterval()
+	timer := time.NewTimer(randomDelay(jitter))
+	defer timer.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-timer.C:
+		}
+		// a poll never takes longer than the interval
+		pollCtx, cancel := context.WithTimeout(ctx, interval)
+		snapshot, err := fetchSnapshot(pollCtx, con)
+		cancel()
+		if err != nil && ctx.Err() == nil {
+			log.Warnf("Polling server %s failed: %s", con.Name(), err)
+		}
+		p.mu.Lock()
+		if err == nil {
+			server.snapshot = snapshot
+		}
+		server.err = err
+		p.mu.Unlock()
+		timer.Reset(interval + randomDelay(jitter))
+	}
+}
+
+// snapshot returns the latest snapshot of the server, ok is false if the
+// server isn't polled. The error of the latest poll is returned with the
+// stale snapshot, so a failing server isn't exported from it.
+func (p *Poller) snapshot(con *connector.Connection) (snapshot *Snapshot, ok bool, err error) {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	server, ok := p.servers[con]
+	if !ok {
+		return nil, false, nil
+	}
+	if server.snapshot == nil && server.err == nil {
+		return nil, true, fmt.Errorf("no snapshot of server %s yet", con.Name())
+	}
+	return server.snapshot, true, server.err
+}
+
+// Describe implements the prometheus.Collector interface.
+func (p *Poller) Describe(ch chan<- *prometheus.Desc) {
+	ch <- snapshotAgeDesc
+}
+
+// Collect implements the prometheus.Collector interface.
+func (p *Poller) Collect(ch chan<- prometheus.Metric) {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	for con, server := range p.servers {
+		if server.snapshot == nil {
+			continue
+		}
+		ch <- prometheus.MustNewConstMetric(snapshotAgeDesc, prometheus.GaugeValue,
+			time.Since(server.snapshot.Time).Seconds(), con.Name())
+	}
+}
+
+// randomDelay returns a random duration below max
+func randomDelay(max time.Duration) time.Duration {
+	if max <= 0 {
+		return 0
+	}
+	return time.Duration(rand.Int63n(int64(max)))
+}
+
+// fetchSnapshot queries the server and parses its state, the status and stats
+// are only queried if a collector exporting them is enabled for the server
+func fetchSnapshot(ctx context.Context, con *connector.Connection) (*Snapshot, error) {
+	if con.Protocol() == connector.ProtocolA2S {
+		info, fetched, err := con.InfoWithTime(ctx)
+		if err != nil {
+			return nil, err
+		}
+		snapshot := &Snapshot{
+			Time:   fetched,
+			Status: a2sStatus(info),
+		}
+		if con.CollectorEnabled("players") {
+			players, err := con.Players(ctx)
+			if err != nil {
+				return nil, err
+			}
+			snapshot.Players = a2sPlayers(players)
+		}
+		if con.CollectorEnabled("rules") {
+			snapshot.Rules, snapshot.RulesErr = con.Rules(ctx)
+		}
+		return snapshot, nil
+	}
+	// the snapshot is as old as the status, a cached status keeps its time
+	snapshot := &Snapshot{}
+	if collectorsEnabled(con, statusCollectors) {
+		resp, fetched, err := con.GetWithTime(ctx, "status")
+		if err != nil {
+			return nil, err
+		}
+		status, err := parser.ParseStatus(resp)
+		if err != nil {
+			return nil, err
+		}
+		snapshot.Time = fetched
+		snapshot.Status = *status
+	}
+	if con.CollectorEnabled("stats") {
+		var fetched time.Time
+		snapshot.Stats, fetched, snapshot.StatsErr = fetchStats(ctx, con)
+		if snapshot.Time.IsZero() {
+			snapshot.Time = fetched
+		}
+	}
+	if snapshot.Time.IsZero() {
+		snapshot.Time = time.Now()
+	}
+	return snapshot, nil
+}
+
+// fetchStats queries the performance stats of the server
+func fetchStats(ctx context.Context, con *connector.Connection) (*models.Stats, time.Time, error) {
+	resp, fetched, err := con.GetWithTime(ctx, "stats")
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	stats, err := parser.ParseStats(resp)
+	return stats, fetched, err
+}
+
+// a2sPlayers returns the players of an A2S_PLAYER response keyed by their
+// names, A2S lists neither the SteamIDs nor the UserIDs of the players
+func a2sPlayers(players []connector.A2SPlayer) map[string]*models.Player {
+	result := make(map[string]*models.Player, len(players))
+	for _, player := range players {
+		result[player.Name] = &models.Player{
+			Username:  player.Name,
+			Connected: player.Duration,
+		}
+	}
+	return result
+}
+
+// a2sStatus returns the status of an A2S_INFO response, it lists no players
+func a2sStatus(info *connector.A2SInfo) models.Status {
+	status := models.Status{
+		Hostname:   info.Name,
+		Version:    info.Version,
+		Secure:     info.VAC,
+		Game:       info.Game,
+		Map:        info.Map,
+		EdictsUsed: -1,
+		EdictsMax:  -1,
+		PlayerCount: models.PlayerCount{
+			Current: int(info.Players),
+			Max:     int(info.MaxPlayers),
+			Humans:  int(info.Players) - int(info.Bots),
+			Bots:    int(info.Bots),
+		},
+	}
+	if info.SteamID != 0 {
+		status.SteamID64 = strconv.FormatUint(info.SteamID, 10)
+	}
+	for _, tag := range strings.Split(info.Keywords, ",") {
+		if tag = strings.TrimSpace(tag); tag != "" {
+			status.Tags = append(status.Tags, tag)
+		}
+	}
+	if info.SourceTV != nil {
+		status.SourceTV = &models.SourceTV{Port: int(info.SourceTV.Port)}
+	}
+	return status
+}
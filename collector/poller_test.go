@@ -0,0 +1,184 @@
+/*
+Copyright 2020 Alexander Trost <galexrt@googlemail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package collector
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/galexrt/srcds_exporter/connector"
+	"github.com/galexrt/srcds_exporter/parser/models"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPoller(t *testing.T) {
+	setupFakeServersWithOptions(t, map[string]map[string]string{
+		"server1": {"status": tf2Status},
+		"server2": {"status": tf2Status},
+	}, func(name string, opts *connector.ConnectionOptions) {
+		if name == "server1" {
+			opts.PollInterval = "20ms"
+		}
+	})
+	p := NewPoller()
+	SetPoller(p)
+	t.Cleanup(func() {
+		p.Stop()
+		SetPoller(nil)
+	})
+	all, err := connections.GetConnections()
+	require.NoError(t, err)
+	p.Sync(all)
+
+	_, ok, _ := p.snapshot(all["server2"])
+	assert.False(t, ok, "server2 has no poll interval")
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		snapshot, ok, err := p.snapshot(all["server1"])
+		require.True(t, ok)
+		if err == nil {
+			assert.Equal(t, "pl_upward", snapshot.Map)
+			assert.Equal(t, 3, snapshot.PlayerCount.Current)
+			assert.Len(t, snapshot.Players, 2)
+			break
+		}
+		require.True(t, time.Now().Before(deadline), "no snapshot of server1")
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	// the polled server is served from its snapshot even though the scrape
+	// has no time left to query the servers
+	c, err := NewMapCollector(nil)
+	require.NoError(t, err)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	ch := make(chan prometheus.Metric, 10)
+	err = c.Update(ctx, ch)
+	assert.True(t, errors.Is(err, context.Canceled))
+	metrics := drain(ch)
+	assert.NoError(t, testutil.CollectAndCompare(metrics, strings.NewReader(`
+# HELP srcds_map_info The current map on the server, the series of the previous map is dropped on a map change.
+# TYPE srcds_map_info gauge
+srcds_map_info{map="pl_upward",server="server1"} 1
+`), "srcds_map_info"))
+
+	assert.Equal(t, 1, testutil.CollectAndCount(p))
+
+	p.Sync(map[string]*connector.Connection{"server2": all["server2"]})
+	_, ok, _ = p.snapshot(all["server1"])
+	assert.False(t, ok, "server1 has been removed")
+	assert.Equal(t, 0, testutil.CollectAndCount(p))
+}
+
+func TestPollerFailure(t *testing.T) {
+	setupFakeServers(t, map[string]map[string]string{
+		"server1": {"status": tf2Status},
+	})
+	all, err := connections.GetConnections()
+	require.NoError(t, err)
+	snapshot, err := fetchSnapshot(context.Background(), all["server1"])
+	require.NoError(t, err)
+
+	// the latest poll failed after an earlier one succeeded
+	p := NewPoller()
+	p.servers[all["server1"]] = &polledServer{
+		cancel:   func() {},
+		snapshot: snapshot,
+		err:      errors.New("connection refused"),
+	}
+	SetPoller(p)
+	t.Cleanup(func() { SetPoller(nil) })
+
+	_, ok, err := p.snapshot(all["server1"])
+	assert.True(t, ok)
+	assert.EqualError(t, err, "connection refused")
+
+	// the stale snapshot isn't exported, only its age
+	c, err := NewMapCollector(nil)
+	require.NoError(t, err)
+	ch := make(chan prometheus.Metric, 10)
+	assert.Error(t, c.Update(context.Background(), ch))
+	assert.Equal(t, 0, testutil.CollectAndCount(drain(ch)))
+	assert.Equal(t, 1, testutil.CollectAndCount(p))
+}
+
+func TestPollerRename(t *testing.T) {
+	// polling fails for server1, the failures are logged with its name
+	setupFakeServersWithOptions(t, map[string]map[string]string{
+		"server1": {},
+	}, func(name string, opts *connector.ConnectionOptions) {
+		opts.PollInterval = "1ms"
+		// the same options as the reloaded servers
+		opts.Collectors = nil
+	})
+	p := NewPoller()
+	t.Cleanup(p.Stop)
+	all, err := connections.GetConnections()
+	require.NoError(t, err)
+	p.Sync(all)
+
+	// renaming the polled server doesn't race with the poll goroutine
+	for _, name := range []string{"server1-renamed", "server1"} {
+		time.Sleep(10 * time.Millisecond)
+		result, err := connections.Reload(map[string]*connector.ConnectionOptions{
+			name: {
+				Addr:           "server1",
+				ConnectTimeout: "1s",
+				CacheTimeout:   "1s",
+				Protocol:       fakeProtocol,
+				PollInterval:   "1ms",
+			},
+		})
+		require.NoError(t, err)
+		assert.Len(t, result.Renamed, 1)
+	}
+	all, err = connections.GetConnections()
+	require.NoError(t, err)
+	assert.Equal(t, "server1", all["server1"].Name())
+}
+
+func TestFetchSnapshotCached(t *testing.T) {
+	setupFakeServers(t, map[string]map[string]string{
+		"server1": {"status": tf2Status},
+	})
+	all, err := connections.GetConnections()
+	require.NoError(t, err)
+
+	first, err := fetchSnapshot(context.Background(), all["server1"])
+	require.NoError(t, err)
+	time.Sleep(10 * time.Millisecond)
+	// the snapshot of the cached status is as old as the status
+	second, err := fetchSnapshot(context.Background(), all["server1"])
+	require.NoError(t, err)
+	assert.Equal(t, first.Time, second.Time)
+}
+
+func TestA2SPlayers(t *testing.T) {
+	assert.Equal(t, map[string]*models.Player{
+		"TestUser1": {Username: "TestUser1", Connected: time.Minute},
+		"TestUser2": {Username: "TestUser2", Connected: 2 * time.Minute},
+	}, a2sPlayers([]connector.A2SPlayer{
+		{Name: "TestUser1", Score: 10, Duration: time.Minute},
+		{Name: "TestUser2", Score: 20, Duration: 2 * time.Minute},
+	}))
+}
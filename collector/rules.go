@@ -0,0 +1,85 @@
+/*
+Copyright 2020 Alexander Trost <galexrt@googlemail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package collector
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+
+	"github.com/galexrt/srcds_exporter/connector"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+type rulesCollector struct {
+	rule *Desc
+}
+
+func init() {
+	Factories["rules"] = NewRulesCollector
+}
+
+// NewRulesCollector returns a new Collector exposing the numeric rules (cvars)
+// of A2S servers.
+func NewRulesCollector(labels []string) (Collector, error) {
+	return &rulesCollector{
+		rule: newDesc("server", "rule", "The numeric rules (cvars) of the server from A2S_RULES.",
+			prometheus.GaugeValue, labels, "rule"),
+	}, nil
+}
+
+func (c *rulesCollector) Descs() []*Desc {
+	return []*Desc{c.rule}
+}
+
+func (c *rulesCollector) Update(ctx context.Context, ch chan<- prometheus.Metric) error {
+	servers := getConnections("rules")
+	for name, con := range servers {
+		// the rules are only queried over A2S
+		if con.Protocol() != connector.ProtocolA2S {
+			delete(servers, name)
+		}
+	}
+	return collectServers("rules", servers, ch, func(con *connector.Connection) error {
+		snapshot, err := getSnapshot(ctx, con)
+		if err != nil {
+			return err
+		}
+		if snapshot.RulesErr != nil {
+			return snapshot.RulesErr
+		}
+		if snapshot.Rules == nil {
+			return fmt.Errorf("no rules of server %s", con.Name())
+		}
+		for name, value := range numericRules(snapshot.Rules) {
+			ch <- c.rule.metric(con, value, name)
+		}
+		return nil
+	})
+}
+
+// numericRules returns the rules with a numeric value, the other values, e.g.,
+// the next map, aren't exported
+func numericRules(rules map[string]string) map[string]float64 {
+	result := make(map[string]float64, len(rules))
+	for name, value := range rules {
+		if v, err := strconv.ParseFloat(value, 64); err == nil {
+			result[name] = v
+		}
+	}
+	return result
+}
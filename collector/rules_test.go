@@ -0,0 +1,37 @@
+/*
+Copyright 2020 Alexander Trost <galexrt@googlemail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package collector
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNumericRules(t *testing.T) {
+	assert.Equal(t, map[string]float64{
+		"mp_timelimit": 30,
+		"sv_gravity":   800,
+		"mp_friction":  4.5,
+	}, numericRules(map[string]string{
+		"mp_timelimit": "30",
+		"sv_gravity":   "800",
+		"mp_friction":  "4.5",
+		"nextlevel":    "pl_upward",
+		"sv_tags":      "",
+	}))
+}
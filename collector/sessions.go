@@ -0,0 +1,162 @@
+/*
+Copyright 2020 Alexander Trost <galexrt@googlemail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package collector
+
+import (
+	"sync"
+	"time"
+
+	"github.com/galexrt/srcds_exporter/connector"
+	"github.com/galexrt/srcds_exporter/parser/models"
+)
+
+// sessionDurationBuckets buckets of the session durations in seconds, from a
+// minute to eight hours
+var sessionDurationBuckets = []float64{60, 300, 900, 1800, 3600, 7200, 14400, 28800}
+
+// playerSessions tracks the sessions of the players per server by diffing the
+// successive player lists, the servers are keyed by connection so their
+// sessions are kept when they're renamed
+type playerSessions struct {
+	mu      sync.Mutex
+	servers map[*connector.Connection]*serverSessions
+}
+
+// serverSessions sessions of the players of a server
+type serverSessions struct {
+	// seen time the players were last seen
+	seen     time.Time
+	sessions map[string]*session
+	stats    sessionStats
+}
+
+// session current session of a player
+type session struct {
+	// start is computed from the connected column so it is accurate across
+	// exporter restarts
+	start     time.Time
+	connected time.Duration
+	userID    int
+}
+
+// sessionStats joins, leaves and the histogram of the durations of the
+// finished sessions of a server
+type sessionStats struct {
+	joins   uint64
+	leaves  uint64
+	count   uint64
+	sum     float64
+	buckets map[float64]uint64
+}
+
+func newPlayerSessions() *playerSessions {
+	return &playerSessions{
+		servers: map[*connector.Connection]*serverSessions{},
+	}
+}
+
+// observe diffs the players of the server seen at t with the players seen
+// before and returns a copy of the session stats of the server. The players
+// seen first are not counted as joins, player lists which aren't newer than
+// the last one are ignored. A player reconnected if the connected time went
+// down or the UserID changed.
+func (s *playerSessions) observe(con *connector.Connection, players map[string]*models.Player, t time.Time) sessionStats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	sessions, ok := s.servers[con]
+	if !ok {
+		sessions = &serverSessions{
+			seen:     t,
+			sessions: make(map[string]*session, len(players)),
+			stats: sessionStats{
+				buckets: make(map[float64]uint64, len(sessionDurationBuckets)),
+			},
+		}
+		for _, bound := range sessionDurationBuckets {
+			sessions.stats.buckets[bound] = 0
+		}
+		for key, player := range players {
+			sessions.sessions[key] = newSession(player, t)
+		}
+		s.servers[con] = sessions
+	} else if t.After(sessions.seen) {
+		for key, player := range players {
+			if previous, ok := sessions.sessions[key]; ok {
+				if player.Connected >= previous.connected && player.UserID == previous.userID {
+					previous.connected = player.Connected
+					continue
+				}
+				// the player reconnected since the players were last seen
+				sessions.leave(previous.start)
+			}
+			sessions.sessions[key] = newSession(player, t)
+			sessions.stats.joins++
+		}
+		for key, previous := range sessions.sessions {
+			if _, ok := players[key]; !ok {
+				sessions.leave(previous.start)
+				delete(sessions.sessions, key)
+			}
+		}
+		sessions.seen = t
+	}
+
+	stats := sessions.stats
+	stats.buckets = make(map[float64]uint64, len(sessions.stats.buckets))
+	for bound, count := range sessions.stats.buckets {
+		stats.buckets[bound] = count
+	}
+	return stats
+}
+
+// newSession returns the session of the player seen at t
+func newSession(player *models.Player, t time.Time) *session {
+	return &session{
+		start:     t.Add(-player.Connected),
+		connected: player.Connected,
+		userID:    player.UserID,
+	}
+}
+
+// leave records the end of the session started at start, the player left
+// after the players were last seen
+func (s *serverSessions) leave(start time.Time) {
+	duration := s.seen.Sub(start).Seconds()
+	if duration < 0 {
+		duration = 0
+	}
+	s.stats.leaves++
+	s.stats.count++
+	s.stats.sum += duration
+	for _, bound := range sessionDurationBuckets {
+		if duration <= bound {
+			s.stats.buckets[bound]++
+		}
+	}
+}
+
+// prune stops tracking the servers which aren't in servers
+func (s *playerSessions) prune(servers map[string]*connector.Connection) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	active := activeConnections(servers)
+	for con := range s.servers {
+		if !active[con] {
+			delete(s.servers, con)
+		}
+	}
+}
@@ -0,0 +1,112 @@
+/*
+Copyright 2020 Alexander Trost <galexrt@googlemail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package collector
+
+import (
+	"testing"
+	"time"
+
+	"github.com/galexrt/srcds_exporter/connector"
+	"github.com/galexrt/srcds_exporter/parser/models"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPlayerSessions(t *testing.T) {
+	s := newPlayerSessions()
+	begin := time.Unix(100000, 0)
+	server1, server2 := &connector.Connection{}, &connector.Connection{}
+
+	// the players seen first are no joins, their sessions started before
+	stats := s.observe(server1, map[string]*models.Player{
+		"a": {Connected: 10 * time.Minute},
+		"b": {Connected: 2 * time.Hour},
+	}, begin)
+	assert.Equal(t, uint64(0), stats.joins)
+	assert.Equal(t, uint64(0), stats.leaves)
+
+	// b left and c joined
+	stats = s.observe(server1, map[string]*models.Player{
+		"a": {Connected: 11 * time.Minute},
+		"c": {Connected: 30 * time.Second},
+	}, begin.Add(time.Minute))
+	assert.Equal(t, uint64(1), stats.joins)
+	assert.Equal(t, uint64(1), stats.leaves)
+	assert.Equal(t, uint64(1), stats.count)
+	// b was last seen after 2 hours on the server
+	assert.Equal(t, float64(2*60*60), stats.sum)
+	assert.Equal(t, uint64(0), stats.buckets[3600])
+	assert.Equal(t, uint64(1), stats.buckets[7200])
+
+	// a reconnected since the players were last seen
+	stats = s.observe(server1, map[string]*models.Player{
+		"a": {Connected: 20 * time.Second},
+		"c": {Connected: 90 * time.Second},
+	}, begin.Add(2*time.Minute))
+	assert.Equal(t, uint64(2), stats.joins)
+	assert.Equal(t, uint64(2), stats.leaves)
+	assert.Equal(t, float64(2*60*60+11*60), stats.sum)
+
+	// player lists older than the last one are ignored
+	stats = s.observe(server1, map[string]*models.Player{}, begin)
+	assert.Equal(t, uint64(2), stats.leaves)
+
+	// everyone left
+	stats = s.observe(server1, map[string]*models.Player{}, begin.Add(3*time.Minute))
+	assert.Equal(t, uint64(2), stats.joins)
+	assert.Equal(t, uint64(4), stats.leaves)
+	assert.Equal(t, uint64(4), stats.count)
+	assert.Equal(t, uint64(1), stats.buckets[60])
+	assert.Equal(t, uint64(2), stats.buckets[300])
+	assert.Equal(t, uint64(4), stats.buckets[28800])
+
+	// the servers are tracked separately
+	stats = s.observe(server2, map[string]*models.Player{}, begin)
+	assert.Equal(t, uint64(0), stats.leaves)
+}
+
+func TestPlayerSessionsCached(t *testing.T) {
+	s := newPlayerSessions()
+	begin := time.Unix(100000, 0)
+	server1 := &connector.Connection{}
+
+	s.observe(server1, map[string]*models.Player{
+		"a": {UserID: 2, Connected: 10 * time.Minute},
+	}, begin)
+	joined := map[string]*models.Player{
+		"a": {UserID: 2, Connected: 11 * time.Minute},
+		"b": {UserID: 3, Connected: 30 * time.Second},
+	}
+	stats := s.observe(server1, joined, begin.Add(time.Minute))
+	assert.Equal(t, uint64(1), stats.joins)
+
+	// the same cached player list observed again, at its own time or later,
+	// are no reconnects
+	for i := 0; i < 3; i++ {
+		stats = s.observe(server1, joined, begin.Add(time.Minute))
+		stats = s.observe(server1, joined, begin.Add(time.Duration(i+2)*time.Minute))
+	}
+	assert.Equal(t, uint64(1), stats.joins)
+	assert.Equal(t, uint64(0), stats.leaves)
+
+	// b reconnected, its new session is longer than the previous one seen
+	stats = s.observe(server1, map[string]*models.Player{
+		"a": {UserID: 2, Connected: 20 * time.Minute},
+		"b": {UserID: 5, Connected: 2 * time.Minute},
+	}, begin.Add(11*time.Minute))
+	assert.Equal(t, uint64(2), stats.joins)
+	assert.Equal(t, uint64(1), stats.leaves)
+}
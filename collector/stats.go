@@ -0,0 +1,94 @@
+/*
+Copyright 2020 Alexander Trost <galexrt@googlemail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package collector
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/galexrt/srcds_exporter/connector"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+type statsCollector struct {
+	fps        *Desc
+	cpu        *Desc
+	netIn      *Desc
+	netOut     *Desc
+	uptime     *Desc
+	mapChanges *Desc
+}
+
+func init() {
+	Factories["stats"] = NewStatsCollector
+}
+
+// NewStatsCollector returns a new Collector exposing the performance stats of
+// the server.
+func NewStatsCollector(labels []string) (Collector, error) {
+	return &statsCollector{
+		fps: newDesc("server", "fps", "The current frames (ticks) per second of the server.",
+			prometheus.GaugeValue, labels),
+		cpu: newDesc("server", "cpu_percent", "The current CPU usage of the server in percent.",
+			prometheus.GaugeValue, labels),
+		netIn: newDesc("server", "net_in_kbps", "The current incoming traffic of the server in kB/s.",
+			prometheus.GaugeValue, labels),
+		netOut: newDesc("server", "net_out_kbps", "The current outgoing traffic of the server in kB/s.",
+			prometheus.GaugeValue, labels),
+		uptime: newDesc("server", "uptime_seconds", "The uptime of the server in seconds (minute precision).",
+			prometheus.GaugeValue, labels),
+		mapChanges: newDesc("server", "map_changes_total", "The map changes since the server started.",
+			prometheus.CounterValue, labels),
+	}, nil
+}
+
+func (c *statsCollector) Descs() []*Desc {
+	return []*Desc{c.fps, c.cpu, c.netIn, c.netOut, c.uptime, c.mapChanges}
+}
+
+func (c *statsCollector) Update(ctx context.Context, ch chan<- prometheus.Metric) error {
+	servers := getConnections("stats")
+	for name, con := range servers {
+		// A2S has no stats command
+		if con.Protocol() == connector.ProtocolA2S {
+			delete(servers, name)
+		}
+	}
+	return collectServers("stats", servers, ch, func(con *connector.Connection) error {
+		snapshot, err := getSnapshot(ctx, con)
+		if err != nil {
+			return err
+		}
+		if snapshot.StatsErr != nil {
+			return snapshot.StatsErr
+		}
+		stats := snapshot.Stats
+		if stats == nil {
+			return fmt.Errorf("no stats of server %s", con.Name())
+		}
+		ch <- c.fps.metric(con, stats.FPS)
+		ch <- c.cpu.metric(con, stats.CPU)
+		ch <- c.netIn.metric(con, stats.NetIn)
+		ch <- c.netOut.metric(con, stats.NetOut)
+		ch <- c.uptime.metric(con, stats.Uptime.Seconds())
+		// older Source games don't list the map changes
+		if stats.MapChanges >= 0 {
+			ch <- c.mapChanges.metric(con, float64(stats.MapChanges))
+		}
+		return nil
+	})
+}
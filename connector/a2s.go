@@ -0,0 +1,493 @@
+/*
+Copyright 2020 Alexander Trost <galexrt@googlemail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package connector
+
+import (
+	"bytes"
+	"compress/bzip2"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"io/ioutil"
+	"math"
+	"net"
+	"time"
+)
+
+const (
+	a2sSinglePacket = -1
+	a2sSplitPacket  = -2
+
+	a2sInfoRequest    = 0x54
+	a2sInfoResponse   = 0x49
+	a2sPlayerRequest  = 0x55
+	a2sPlayerResponse = 0x44
+	a2sRulesRequest   = 0x56
+	a2sRulesResponse  = 0x45
+	a2sChallenge      = 0x41
+
+	// a2sMaxChallenges limits how often a server may answer with a new challenge
+	// before the query is given up.
+	a2sMaxChallenges = 3
+)
+
+var (
+	// ErrA2SInvalidResponse A2S response had an unexpected header or type
+	ErrA2SInvalidResponse = errors.New("a2s: invalid response from server")
+	// ErrA2SChecksum A2S decompressed split packet response failed the CRC32 check
+	ErrA2SChecksum = errors.New("a2s: checksum mismatch in compressed response")
+)
+
+// A2SInfo contains the server information returned by A2S_INFO
+type A2SInfo struct {
+	Protocol    uint8
+	Name        string
+	Map         string
+	Folder      string
+	Game        string
+	AppID       uint16
+	Players     uint8
+	MaxPlayers  uint8
+	Bots        uint8
+	ServerType  string
+	Environment string
+	Visibility  bool
+	VAC         bool
+	Version     string
+	Port        uint16
+	SteamID     uint64
+	SourceTV    *A2SSourceTV
+	Keywords    string
+	GameID      uint64
+}
+
+// A2SSourceTV contains the SourceTV information of an A2S_INFO response
+type A2SSourceTV struct {
+	Port uint16
+	Name string
+}
+
+// A2SPlayer contains a single player returned by A2S_PLAYER
+type A2SPlayer struct {
+	Index    uint8
+	Name     string
+	Score    int32
+	Duration time.Duration
+}
+
+// A2SClient queries a server using the Steam A2S UDP protocol
+type A2SClient struct {
+	addr    string
+	timeout time.Duration
+}
+
+// NewA2SClient creates a new A2S client for the given address
+func NewA2SClient(addr string, timeout time.Duration) *A2SClient {
+	return &A2SClient{
+		addr:    addr,
+		timeout: timeout,
+	}
+}
+
+// Info queries the server information using A2S_INFO
+func (q *A2SClient) Info(ctx context.Context) (*A2SInfo, error) {
+	payload := append([]byte{a2sInfoRequest}, []byte("Source Engine Query\x00")...)
+	data, err := q.query(ctx, payload, a2sInfoResponse, true)
+	if err != nil {
+		return nil, err
+	}
+	return parseA2SInfo(data)
+}
+
+// Players queries the players on the server using A2S_PLAYER
+func (q *A2SClient) Players(ctx context.Context) ([]A2SPlayer, error) {
+	data, err := q.query(ctx, []byte{a2sPlayerRequest, 0xFF, 0xFF, 0xFF, 0xFF}, a2sPlayerResponse, false)
+	if err != nil {
+		return nil, err
+	}
+	return parseA2SPlayers(data)
+}
+
+// Rules queries the server rules (cvars) using A2S_RULES
+func (q *A2SClient) Rules(ctx context.Context) (map[string]string, error) {
+	data, err := q.query(ctx, []byte{a2sRulesRequest, 0xFF, 0xFF, 0xFF, 0xFF}, a2sRulesResponse, false)
+	if err != nil {
+		return nil, err
+	}
+	return parseA2SRules(data)
+}
+
+// query sends the payload and handles the challenge handshake. When
+// appendChallenge is true the challenge is appended to the payload (A2S_INFO),
+// otherwise it replaces the last four bytes of the payload (A2S_PLAYER and
+// A2S_RULES).
+func (q *A2SClient) query(ctx context.Context, payload []byte, expected byte, appendChallenge bool) ([]byte, error) {
+	dialer := net.Dialer{Timeout: q.timeout}
+	conn, err := dialer.DialContext(ctx, "udp", q.addr)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	// unblock pending reads when ctx is done
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+			conn.SetDeadline(time.Now())
+		case <-done:
+		}
+	}()
+
+	req := payload
+	for i := 0; i < a2sMaxChallenges; i++ {
+		data, err := q.roundTrip(ctx, conn, req)
+		if err != nil {
+			return nil, contextError(ctx, err)
+		}
+		if len(data) < 1 {
+			return nil, ErrA2SInvalidResponse
+		}
+		switch data[0] {
+		case expected:
+			return data[1:], nil
+		case a2sChallenge:
+			if len(data) < 5 {
+				return nil, ErrA2SInvalidResponse
+			}
+			if appendChallenge {
+				req = make([]byte, 0, len(payload)+4)
+				req = append(req, payload...)
+				req = append(req, data[1:5]...)
+			} else {
+				req = make([]byte, len(payload))
+				copy(req, payload)
+				copy(req[len(req)-4:], data[1:5])
+			}
+		default:
+			return nil, fmt.Errorf("a2s: unexpected response type 0x%02x", data[0])
+		}
+	}
+	return nil, fmt.Errorf("a2s: server %s kept sending challenges", q.addr)
+}
+
+// roundTrip sends a single request and returns the (reassembled) response
+// payload with the packet header stripped.
+func (q *A2SClient) roundTrip(ctx context.Context, conn net.Conn, payload []byte) ([]byte, error) {
+	req := make([]byte, 0, len(payload)+4)
+	req = append(req, 0xFF, 0xFF, 0xFF, 0xFF)
+	req = append(req, payload...)
+	deadline := time.Now().Add(q.timeout)
+	if d, ok := ctx.Deadline(); ok && d.Before(deadline) {
+		deadline = d
+	}
+	if err := conn.SetDeadline(deadline); err != nil {
+		return nil, err
+	}
+	if _, err := conn.Write(req); err != nil {
+		return nil, err
+	}
+
+	var split *a2sSplitResponse
+	buf := make([]byte, 65535)
+	for {
+		n, err := conn.Read(buf)
+		if err != nil {
+			return nil, err
+		}
+		packet := buf[:n]
+		if len(packet) < 4 {
+			return nil, ErrA2SInvalidResponse
+		}
+		header := int32(binary.LittleEndian.Uint32(packet))
+		switch header {
+		case a2sSinglePacket:
+			out := make([]byte, n-4)
+			copy(out, packet[4:])
+			return out, nil
+		case a2sSplitPacket:
+			if split == nil {
+				split = &a2sSplitResponse{}
+			}
+			done, err := split.add(packet[4:])
+			if err != nil {
+				return nil, err
+			}
+			if done {
+				return split.assemble()
+			}
+		default:
+			return nil, ErrA2SInvalidResponse
+		}
+	}
+}
+
+// a2sSplitResponse reassembles a response which was split across packets.
+type a2sSplitResponse struct {
+	id       int32
+	total    int
+	packets  [][]byte
+	received int
+	size     uint32
+	checksum uint32
+}
+
+func (s *a2sSplitResponse) add(packet []byte) (bool, error) {
+	r := bytes.NewReader(packet)
+	var (
+		id     int32
+		total  uint8
+		number uint8
+	)
+	if err := binary.Read(r, binary.LittleEndian, &id); err != nil {
+		return false, err
+	}
+	if err := binary.Read(r, binary.LittleEndian, &total); err != nil {
+		return false, err
+	}
+	if err := binary.Read(r, binary.LittleEndian, &number); err != nil {
+		return false, err
+	}
+	if total == 0 || number >= total {
+		return false, ErrA2SInvalidResponse
+	}
+	if s.packets == nil {
+		s.id = id
+		s.total = int(total)
+		s.packets = make([][]byte, total)
+	} else if s.id != id || s.total != int(total) {
+		return false, ErrA2SInvalidResponse
+	}
+	var maxSize uint16
+	if err := binary.Read(r, binary.LittleEndian, &maxSize); err != nil {
+		return false, err
+	}
+	if s.compressed() && number == 0 {
+		if err := binary.Read(r, binary.LittleEndian, &s.size); err != nil {
+			return false, err
+		}
+		if err := binary.Read(r, binary.LittleEndian, &s.checksum); err != nil {
+			return false, err
+		}
+	}
+	if s.packets[number] == nil {
+		s.received++
+	}
+	payload, _ := ioutil.ReadAll(r)
+	s.packets[number] = payload
+	return s.received == s.total, nil
+}
+
+func (s *a2sSplitResponse) compressed() bool {
+	return uint32(s.id)&0x80000000 != 0
+}
+
+func (s *a2sSplitResponse) assemble() ([]byte, error) {
+	data := bytes.Join(s.packets, nil)
+	if s.compressed() {
+		decompressed, err := ioutil.ReadAll(bzip2.NewReader(bytes.NewReader(data)))
+		if err != nil {
+			return nil, err
+		}
+		if uint32(len(decompressed)) != s.size || crc32.ChecksumIEEE(decompressed) != s.checksum {
+			return nil, ErrA2SChecksum
+		}
+		data = decompressed
+	}
+	// The reassembled payload starts with the single packet header again.
+	if len(data) < 4 || int32(binary.LittleEndian.Uint32(data)) != a2sSinglePacket {
+		return nil, ErrA2SInvalidResponse
+	}
+	return data[4:], nil
+}
+
+type a2sReader struct {
+	r   *bytes.Reader
+	err error
+}
+
+func newA2SReader(data []byte) *a2sReader {
+	return &a2sReader{r: bytes.NewReader(data)}
+}
+
+func (r *a2sReader) read(v interface{}) {
+	if r.err != nil {
+		return
+	}
+	r.err = binary.Read(r.r, binary.LittleEndian, v)
+}
+
+func (r *a2sReader) byte() uint8 {
+	var v uint8
+	r.read(&v)
+	return v
+}
+
+func (r *a2sReader) short() uint16 {
+	var v uint16
+	r.read(&v)
+	return v
+}
+
+func (r *a2sReader) long() int32 {
+	var v int32
+	r.read(&v)
+	return v
+}
+
+func (r *a2sReader) longLong() uint64 {
+	var v uint64
+	r.read(&v)
+	return v
+}
+
+func (r *a2sReader) float() float32 {
+	var v uint32
+	r.read(&v)
+	return math.Float32frombits(v)
+}
+
+func (r *a2sReader) string() string {
+	if r.err != nil {
+		return ""
+	}
+	var buf bytes.Buffer
+	for {
+		b, err := r.r.ReadByte()
+		if err != nil {
+			r.err = io.ErrUnexpectedEOF
+			return ""
+		}
+		if b == 0 {
+			break
+		}
+		buf.WriteByte(b)
+	}
+	return buf.String()
+}
+
+func (r *a2sReader) more() bool {
+	return r.err == nil && r.r.Len() > 0
+}
+
+func parseA2SInfo(data []byte) (*A2SInfo, error) {
+	r := newA2SReader(data)
+	info := &A2SInfo{
+		Protocol: r.byte(),
+		Name:     r.string(),
+		Map:      r.string(),
+		Folder:   r.string(),
+		Game:     r.string(),
+		AppID:    r.short(),
+	}
+	info.Players = r.byte()
+	info.MaxPlayers = r.byte()
+	info.Bots = r.byte()
+	switch r.byte() {
+	case 'd':
+		info.ServerType = "dedicated"
+	case 'l':
+		info.ServerType = "listen"
+	case 'p':
+		info.ServerType = "proxy"
+	}
+	switch r.byte() {
+	case 'l':
+		info.Environment = "linux"
+	case 'w':
+		info.Environment = "windows"
+	case 'm', 'o':
+		info.Environment = "mac"
+	}
+	info.Visibility = r.byte() == 1
+	info.VAC = r.byte() == 1
+	info.Version = r.string()
+	if r.err != nil {
+		return nil, fmt.Errorf("a2s: decoding info response. %+v", r.err)
+	}
+	if !r.more() {
+		return info, nil
+	}
+
+	edf := r.byte()
+	if edf&0x80 != 0 {
+		info.Port = r.short()
+	}
+	if edf&0x10 != 0 {
+		info.SteamID = r.longLong()
+	}
+	if edf&0x40 != 0 {
+		info.SourceTV = &A2SSourceTV{
+			Port: r.short(),
+			Name: r.string(),
+		}
+	}
+	if edf&0x20 != 0 {
+		info.Keywords = r.string()
+	}
+	if edf&0x01 != 0 {
+		info.GameID = r.longLong()
+	}
+	if r.err != nil {
+		return nil, fmt.Errorf("a2s: decoding info response. %+v", r.err)
+	}
+	return info, nil
+}
+
+func parseA2SPlayers(data []byte) ([]A2SPlayer, error) {
+	r := newA2SReader(data)
+	count := int(r.byte())
+	players := make([]A2SPlayer, 0, count)
+	for i := 0; i < count && r.more(); i++ {
+		p := A2SPlayer{
+			Index: r.byte(),
+			Name:  r.string(),
+			Score: r.long(),
+		}
+		p.Duration = time.Duration(float64(r.float()) * float64(time.Second))
+		if r.err != nil {
+			break
+		}
+		players = append(players, p)
+	}
+	if r.err != nil {
+		return nil, fmt.Errorf("a2s: decoding player response. %+v", r.err)
+	}
+	return players, nil
+}
+
+func parseA2SRules(data []byte) (map[string]string, error) {
+	r := newA2SReader(data)
+	count := int(r.short())
+	rules := make(map[string]string, count)
+	for i := 0; i < count && r.more(); i++ {
+		name := r.string()
+		value := r.string()
+		if r.err != nil {
+			break
+		}
+		rules[name] = value
+	}
+	if r.err != nil {
+		return nil, fmt.Errorf("a2s: decoding rules response. %+v", r.err)
+	}
+	return rules, nil
+}
@@ -0,0 +1,173 @@
+/*
+Copyright 2020 Alexander Trost <galexrt@googlemail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package connector
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"math"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var a2sTestChallenge = []byte{0x11, 0x22, 0x33, 0x44}
+
+// a2sStub answers A2S queries, requiring the challenge and splitting rules
+// responses into multiple packets.
+func a2sStub(t *testing.T) string {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+
+	go func() {
+		buf := make([]byte, 1400)
+		for {
+			n, addr, err := conn.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			req := buf[:n]
+			if len(req) < 5 {
+				continue
+			}
+			if !bytes.HasSuffix(req, a2sTestChallenge) {
+				conn.WriteTo(append([]byte{0xFF, 0xFF, 0xFF, 0xFF, a2sChallenge}, a2sTestChallenge...), addr)
+				continue
+			}
+			switch req[4] {
+			case a2sInfoRequest:
+				conn.WriteTo(a2sTestInfo(), addr)
+			case a2sPlayerRequest:
+				conn.WriteTo(a2sTestPlayers(), addr)
+			case a2sRulesRequest:
+				for _, p := range a2sTestRules() {
+					conn.WriteTo(p, addr)
+				}
+			}
+		}
+	}()
+	return conn.LocalAddr().String()
+}
+
+func a2sTestInfo() []byte {
+	buf := &bytes.Buffer{}
+	buf.Write([]byte{0xFF, 0xFF, 0xFF, 0xFF, a2sInfoResponse, 17})
+	buf.WriteString("Test Server\x00pl_upward\x00tf\x00Team Fortress\x00")
+	binary.Write(buf, binary.LittleEndian, uint16(440))
+	buf.Write([]byte{20, 24, 2, 'd', 'l', 0, 1})
+	buf.WriteString("6300758\x00")
+	buf.WriteByte(0x80 | 0x10 | 0x20)
+	binary.Write(buf, binary.LittleEndian, uint16(27015))
+	binary.Write(buf, binary.LittleEndian, uint64(85568392920039000))
+	buf.WriteString("alltalk,increased_maxplayers\x00")
+	return buf.Bytes()
+}
+
+func a2sTestPlayers() []byte {
+	buf := &bytes.Buffer{}
+	buf.Write([]byte{0xFF, 0xFF, 0xFF, 0xFF, a2sPlayerResponse, 2})
+	for i, name := range []string{"TestUser1", "TestUser2"} {
+		buf.WriteByte(0)
+		buf.WriteString(name + "\x00")
+		binary.Write(buf, binary.LittleEndian, int32(10*(i+1)))
+		binary.Write(buf, binary.LittleEndian, math.Float32bits(float32(60*(i+1))))
+	}
+	return buf.Bytes()
+}
+
+// a2sTestRules returns the rules response split into two packets, the second
+// packet is sent first to verify the reassembly is order independent.
+func a2sTestRules() [][]byte {
+	payload := &bytes.Buffer{}
+	payload.Write([]byte{0xFF, 0xFF, 0xFF, 0xFF, a2sRulesResponse})
+	binary.Write(payload, binary.LittleEndian, uint16(2))
+	payload.WriteString("mp_timelimit\x0030\x00sv_gravity\x00800\x00")
+	data := payload.Bytes()
+
+	half := len(data) / 2
+	packets := [][]byte{}
+	for i, part := range [][]byte{data[half:], data[:half]} {
+		buf := &bytes.Buffer{}
+		binary.Write(buf, binary.LittleEndian, int32(a2sSplitPacket))
+		binary.Write(buf, binary.LittleEndian, int32(1234))
+		buf.Write([]byte{2, byte(1 - i)})
+		binary.Write(buf, binary.LittleEndian, uint16(1248))
+		buf.Write(part)
+		packets = append(packets, buf.Bytes())
+	}
+	return packets
+}
+
+func TestA2SInfo(t *testing.T) {
+	q := NewA2SClient(a2sStub(t), time.Second)
+	info, err := q.Info(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, &A2SInfo{
+		Protocol:    17,
+		Name:        "Test Server",
+		Map:         "pl_upward",
+		Folder:      "tf",
+		Game:        "Team Fortress",
+		AppID:       440,
+		Players:     20,
+		MaxPlayers:  24,
+		Bots:        2,
+		ServerType:  "dedicated",
+		Environment: "linux",
+		Visibility:  false,
+		VAC:         true,
+		Version:     "6300758",
+		Port:        27015,
+		SteamID:     85568392920039000,
+		Keywords:    "alltalk,increased_maxplayers",
+	}, info)
+}
+
+func TestA2SPlayers(t *testing.T) {
+	q := NewA2SClient(a2sStub(t), time.Second)
+	players, err := q.Players(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, []A2SPlayer{
+		{Name: "TestUser1", Score: 10, Duration: time.Minute},
+		{Name: "TestUser2", Score: 20, Duration: 2 * time.Minute},
+	}, players)
+}
+
+func TestA2SRules(t *testing.T) {
+	q := NewA2SClient(a2sStub(t), time.Second)
+	rules, err := q.Rules(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{
+		"mp_timelimit": "30",
+		"sv_gravity":   "800",
+	}, rules)
+}
+
+func TestA2STimeout(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer conn.Close()
+
+	q := NewA2SClient(conn.LocalAddr().String(), 50*time.Millisecond)
+	_, err = q.Info(context.Background())
+	assert.Error(t, err)
+}
@@ -17,67 +17,651 @@ limitations under the License.
 package connector
 
 import (
+	"context"
+	"errors"
+	"fmt"
 	"sync"
 	"time"
 
-	rcon "github.com/galexrt/go-rcon"
 	cache "github.com/patrickmn/go-cache"
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/sync/singleflight"
+)
+
+const (
+	// ProtocolRCON queries the server using Source RCON (default)
+	ProtocolRCON = "rcon"
+	// ProtocolA2S queries the server using the password-less A2S UDP protocol
+	ProtocolA2S = "a2s"
+	// ProtocolGoldSrc queries GoldSrc (HLDS) servers using `challenge rcon`
+	// over UDP
+	ProtocolGoldSrc = "goldsrc"
+)
+
+var (
+	// ErrRCONUnavailable RCON commands can't be sent over an A2S connection
+	ErrRCONUnavailable = errors.New("connector: rcon is not available for a2s connections")
+	// ErrNotConnected the connection is currently not established
+	ErrNotConnected = errors.New("connector: not connected")
+	// ErrAuthFailed the server rejected the RCON password. Once the failure
+	// budget is exhausted the connection isn't retried until the server
+	// config changes.
+	ErrAuthFailed = errors.New("connector: rcon authentication failed")
+	// ErrPasswordUnavailable the RCON password of the server couldn't be
+	// resolved
+	ErrPasswordUnavailable = errors.New("connector: rcon password unavailable")
+	// ErrTimeout the server didn't answer in time
+	ErrTimeout = errors.New("connector: timeout")
+	// ErrConnectionRefused the server refused the connection
+	ErrConnectionRefused = errors.New("connector: connection refused")
+	// ErrInvalidResponse the response of the server couldn't be decoded
+	ErrInvalidResponse = errors.New("connector: invalid response")
+
+	// errClosed the connection was closed while connecting
+	errClosed = errors.New("connector: connection closed")
 )
 
 // ConnectionOptions options for a Connection
 type ConnectionOptions struct {
-	Addr           string
-	RconPassword   string
-	ConnectTimeout string
-	CacheTimeout   string
+	Addr         string
+	RconPassword string
+	// PasswordUnavailable the RCON password couldn't be resolved, the server
+	// isn't connected until a password is set with UpdatePassword
+	PasswordUnavailable bool
+	ConnectTimeout      string
+	// CacheTimeout how long responses are cached, a timeout of zero disables
+	// caching
+	CacheTimeout string
+	// CommandCacheTimeouts overrides the CacheTimeout per command, a timeout
+	// of zero disables caching for the command
+	CommandCacheTimeouts map[string]string
+	Protocol             string
+	// KeepaliveInterval interval in which idle sessions are probed, defaults
+	// to DefaultKeepaliveInterval
+	KeepaliveInterval string
+	// MaxSessionAge sessions older than this are reopened, zero keeps the
+	// session open until an I/O error occurs
+	MaxSessionAge string
+	// AuthFailureBudget number of authentication failures tolerated within
+	// the AuthFailureWindow before giving up, defaults to 1. srcds bans the
+	// exporter after sv_rcon_maxfailures failures, keep it below that value.
+	AuthFailureBudget int
+	// AuthFailureWindow window the AuthFailureBudget applies to
+	AuthFailureWindow string
+	// Collectors enabled for the server, all collectors are enabled if empty
+	Collectors []string
+	// Labels additional labels added to the collector metrics of the server
+	Labels map[string]string
+	// PollInterval interval in which the server is polled in the background,
+	// zero queries the server on every scrape
+	PollInterval string
+	// PollJitter maximum random delay added to the PollInterval, defaults to
+	// a tenth of the PollInterval
+	PollJitter string
+	// PacketGap how long the GoldSrc transport waits for further packets of a
+	// response, defaults to DefaultGoldSrcPacketGap. Zero returns the first
+	// packet only.
+	PacketGap string
 }
 
+// DefaultKeepaliveInterval default interval in which idle sessions are probed
+const DefaultKeepaliveInterval = "30s"
+
+// DefaultAuthFailureWindow default window of the authentication failure budget
+const DefaultAuthFailureWindow = "1h"
+
 // Connection struct contains all variables necessary for the connection
 type Connection struct {
-	Name    string
+	// name is guarded by mu, it changes when the server is renamed
+	name    string
 	cmu     sync.Mutex
-	con     *rcon.Server
+	con     Transport
+	query   *A2SClient
 	mu      sync.Mutex
 	cache   cache.Cache
-	opts    map[string]string
-	created time.Time
+	group   singleflight.Group
+	stats   *connectionStats
+	opts    ConnectionOptions
+	timeout time.Duration
+	// created and lastUsed are guarded by cmu
+	created  time.Time
+	lastUsed time.Time
+
+	cacheTimeout  time.Duration
+	cacheTimeouts map[string]time.Duration
+	keepalive     time.Duration
+	maxSessionAge time.Duration
+	authWindow    time.Duration
+	pollInterval  time.Duration
+	pollJitter    time.Duration
+
+	// state, lastErr, connecting, authFailures and sessionCreated are
+	// guarded by mu. sessionCreated is the created time of the current
+	// session, read without waiting for a request or dial holding cmu.
+	state          State
+	lastErr        error
+	connecting     bool
+	authFailures   []time.Time
+	sessionCreated time.Time
+	backoff        backoff
+	done           chan struct{}
+	closeOnce      sync.Once
+}
+
+// Protocol returns the protocol used to query the server
+func (c *Connection) Protocol() string {
+	return c.opts.Protocol
+}
+
+// Labels returns the additional labels of the server
+func (c *Connection) Labels() map[string]string {
+	return c.opts.Labels
+}
+
+// PollInterval returns the interval and jitter the server is polled with in
+// the background, an interval of zero disables polling
+func (c *Connection) PollInterval() (time.Duration, time.Duration) {
+	return c.pollInterval, c.pollJitter
+}
+
+// CollectorEnabled returns whether the collector is enabled for the server
+func (c *Connection) CollectorEnabled(name string) bool {
+	if len(c.opts.Collectors) == 0 {
+		return true
+	}
+	for _, collector := range c.opts.Collectors {
+		if collector == name {
+			return true
+		}
+	}
+	return false
+}
+
+// rename changes the name of the connection. The caller must ensure that no
+// scrape reads the name concurrently.
+func (c *Connection) rename(name string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.name = name
+}
+
+// Name returns the name of the server
+func (c *Connection) Name() string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.name
+}
+
+// start establishes the connection unless no session is needed
+func (c *Connection) start() {
+	if c.query == nil {
+		c.connect()
+		go c.keepaliveLoop()
+	}
+}
+
+// keepaliveLoop probes idle sessions so broken sessions are noticed and
+// reopened before the next scrape needs them
+func (c *Connection) keepaliveLoop() {
+	ticker := time.NewTicker(c.keepalive)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+		case <-c.done:
+			return
+		}
+		if c.State() != StateHealthy {
+			continue
+		}
+		c.cmu.Lock()
+		if c.con != nil && time.Since(c.lastUsed) >= c.keepalive {
+			if err := c.ensureSession(); err == nil {
+				if err := c.con.Health(); err != nil {
+					c.failed(err)
+				} else {
+					c.lastUsed = time.Now()
+				}
+			}
+		}
+		c.cmu.Unlock()
+	}
+}
+
+// ensureSession reopens the session when it exceeded the max session age,
+// must be called with cmu held
+func (c *Connection) ensureSession() error {
+	if c.maxSessionAge <= 0 || time.Since(c.created) < c.maxSessionAge {
+		return nil
+	}
+	if err := c.reconnect(); err != nil {
+		c.failed(err)
+		return err
+	}
+	return nil
+}
+
+// SessionAge returns the age of the current session, zero if there is none
+func (c *Connection) SessionAge() time.Duration {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.sessionCreated.IsZero() {
+		return 0
+	}
+	return time.Since(c.sessionCreated)
+}
+
+// State returns the current state of the connection
+func (c *Connection) State() State {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.state
+}
+
+// LastError returns the error which caused the connection to leave the
+// healthy state
+func (c *Connection) LastError() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.lastErr
+}
+
+// setState must be called with mu held
+func (c *Connection) setState(state State, err error) {
+	if c.state != state {
+		fields := log.Fields{
+			"server": c.name,
+			"from":   c.state.String(),
+			"to":     state.String(),
+		}
+		if err != nil {
+			log.WithFields(fields).Warnf("connection state changed: %v", err)
+		} else {
+			log.WithFields(fields).Info("connection state changed")
+		}
+	}
+	c.state = state
+	c.lastErr = err
+}
+
+// connect establishes the connection in the background, unless an attempt
+// is already in progress, the server rejected the RCON password or there is
+// no password
+func (c *Connection) connect() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.connecting || c.state == StateAuthFailed || c.state == StateSecretFailed {
+		return
+	}
+	c.connecting = true
+	c.setState(StateConnecting, c.lastErr)
+	go c.connectLoop()
+}
+
+func (c *Connection) connectLoop() {
+	for {
+		// Close may have been called during the backoff delay
+		if c.closed() {
+			c.mu.Lock()
+			c.connecting = false
+			c.mu.Unlock()
+			return
+		}
+		c.cmu.Lock()
+		err := c.reconnect()
+		c.cmu.Unlock()
+
+		c.mu.Lock()
+		if errors.Is(err, errClosed) {
+			c.connecting = false
+			c.mu.Unlock()
+			return
+		}
+		if err == nil {
+			c.backoff.reset()
+			c.connecting = false
+			c.setState(StateHealthy, nil)
+			c.mu.Unlock()
+			return
+		}
+		if isAuthError(err) && c.authFailed(err) {
+			c.connecting = false
+			c.mu.Unlock()
+			return
+		}
+		delay := c.backoff.next()
+		c.setState(StateBackoff, err)
+		c.mu.Unlock()
+
+		select {
+		case <-time.After(delay):
+		case <-c.done:
+			c.mu.Lock()
+			c.connecting = false
+			c.mu.Unlock()
+			return
+		}
+		c.mu.Lock()
+		c.setState(StateConnecting, err)
+		c.mu.Unlock()
+	}
+}
+
+// setPassword changes the RCON password used for new sessions, existing
+// sessions stay authenticated. A connection which gave up after rejected
+// passwords or had no password starts connecting again. Returns whether the
+// password changed.
+func (c *Connection) setPassword(password string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.opts.RconPassword == password && !c.opts.PasswordUnavailable {
+		return false
+	}
+	c.opts.RconPassword = password
+	c.opts.PasswordUnavailable = false
+	if (c.state == StateAuthFailed || c.state == StateSecretFailed) && !c.connecting {
+		c.authFailures = nil
+		c.connecting = true
+		c.setState(StateConnecting, c.lastErr)
+		go c.connectLoop()
+	}
+	return true
+}
+
+// failed marks the connection as broken and starts reconnecting
+func (c *Connection) failed(err error) {
+	c.mu.Lock()
+	if isAuthError(err) && c.authFailed(err) {
+		c.mu.Unlock()
+		return
+	}
+	c.lastErr = err
+	c.mu.Unlock()
+	c.connect()
+}
+
+// authFailed records an authentication failure and switches to the auth
+// failed state when the failure budget is exhausted, must be called with mu
+// held. Returns true when no further attempts may be made.
+func (c *Connection) authFailed(err error) bool {
+	c.stats.authFailure()
+	now := time.Now()
+	failures := c.authFailures[:0]
+	for _, t := range c.authFailures {
+		if now.Sub(t) < c.authWindow {
+			failures = append(failures, t)
+		}
+	}
+	c.authFailures = append(failures, now)
+	if len(c.authFailures) < c.opts.AuthFailureBudget {
+		return false
+	}
+	c.setState(StateAuthFailed, fmt.Errorf("%w, giving up after %d failures: %v",
+		ErrAuthFailed, len(c.authFailures), err))
+	return true
+}
+
+func isAuthError(err error) bool {
+	return errors.Is(err, ErrAuthFailed)
+}
+
+func isContextError(err error) bool {
+	return errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded)
 }
 
 func (c *Connection) reconnect() error {
-	con, err := rcon.Connect(c.opts["Address"], &rcon.ConnectOptions{
-		RCONPassword: c.opts["RCONPassword"],
-		Timeout:      c.opts["Timeout"],
-	})
+	dial, ok := Transports[c.opts.Protocol]
+	if !ok {
+		return fmt.Errorf("connector: no transport for protocol %s", c.opts.Protocol)
+	}
+	c.mu.Lock()
+	opts := c.opts
+	c.mu.Unlock()
+	con, err := dial(&opts)
 	if err != nil {
+		c.stats.requestError(connectCommand, err)
 		return err
 	}
+	// Close closes done before it waits for cmu, a connection closed while
+	// dialing must not keep the new session
+	if c.closed() {
+		con.Close()
+		return errClosed
+	}
+	if c.con != nil {
+		c.con.Close()
+	}
+	if !c.created.IsZero() {
+		c.stats.reconnect()
+	}
 	c.con = con
 	c.created = time.Now()
+	c.lastUsed = c.created
+	c.mu.Lock()
+	c.sessionCreated = c.created
+	c.mu.Unlock()
 	return nil
 }
 
-// Get return rcon command response
-func (c *Connection) Get(cmd string) (string, error) {
+// Get return rcon command response. Concurrent calls for the same command
+// share a single request to the server. Get returns when ctx is done, the
+// request is cancelled with the context of the caller which started it.
+func (c *Connection) Get(ctx context.Context, cmd string) (string, error) {
+	out, _, err := c.GetWithTime(ctx, cmd)
+	return out, err
+}
+
+// GetWithTime is Get which also returns the time the response was received
+// from the server, a cached response keeps the time it was received at
+func (c *Connection) GetWithTime(ctx context.Context, cmd string) (string, time.Time, error) {
+	if c.query != nil {
+		return "", time.Time{}, ErrRCONUnavailable
+	}
+	resp, err := c.fetch(ctx, cmd, func(ctx context.Context) (interface{}, error) {
+		return c.send(ctx, cmd)
+	})
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	return resp.value.(string), resp.time, nil
+}
+
+// send sends the rcon command to the server
+func (c *Connection) send(ctx context.Context, cmd string) (string, error) {
 	c.cmu.Lock()
 	defer c.cmu.Unlock()
-	out, found := c.cache.Get(cmd)
-	if !found {
-		if (time.Now().Unix() - c.created.Unix()) > 5 {
-			if err := c.reconnect(); err != nil {
-				return "", err
-			}
-			c.created = time.Now()
+	// the caller might have given up while waiting for the lock
+	if err := ctx.Err(); err != nil {
+		return "", err
+	}
+	if state := c.State(); state == StateAuthFailed {
+		err := c.LastError()
+		c.stats.requestError(cmd, err)
+		return "", err
+	} else if state != StateHealthy || c.con == nil {
+		err := fmt.Errorf("%w (%s)", ErrNotConnected, state)
+		c.stats.requestError(cmd, err)
+		return "", err
+	}
+	if err := c.ensureSession(); err != nil {
+		return "", err
+	}
+	begin := time.Now()
+	out, err := c.con.Send(ctx, cmd)
+	c.stats.request(cmd, time.Since(begin), len(cmd), len(out))
+	if err != nil {
+		c.stats.requestError(cmd, err)
+		if errors.Is(err, ErrTruncatedResponse) {
+			c.stats.truncatedResponse(cmd)
+		}
+		// a request which was given up on doesn't break the session
+		if !isContextError(err) {
+			c.failed(err)
 		}
-		var err error
-		if out, err = c.con.Send(cmd); err != nil {
-			return "", err
+		return "", err
+	}
+	c.lastUsed = time.Now()
+	return out, nil
+}
+
+// requestContext returns the context of a request shared by callers, it is
+// limited by the connection timeout and cancelled when the connection closes
+func (c *Connection) requestContext() (context.Context, context.CancelFunc) {
+	var (
+		ctx    context.Context
+		cancel context.CancelFunc
+	)
+	if c.timeout > 0 {
+		ctx, cancel = context.WithTimeout(context.Background(), c.timeout)
+	} else {
+		ctx, cancel = context.WithCancel(context.Background())
+	}
+	go func() {
+		select {
+		case <-c.done:
+			cancel()
+		case <-ctx.Done():
 		}
-		c.cache.Add(cmd, out.(string), cache.DefaultExpiration)
+	}()
+	return ctx, cancel
+}
+
+// response cached response and the time it was received
+type response struct {
+	value interface{}
+	time  time.Time
+}
+
+// cached returns the value of the cached response for key or calls fn to
+// fetch it, see fetch
+func (c *Connection) cached(ctx context.Context, key string, fn func(ctx context.Context) (interface{}, error)) (interface{}, error) {
+	resp, err := c.fetch(ctx, key, fn)
+	if err != nil {
+		return nil, err
+	}
+	return resp.value, nil
+}
+
+// fetch returns the cached response for key or calls fn to fetch it. Only one
+// fn call per key is in flight, concurrent callers wait for and share its result
+// until their ctx is done. fn is called with the context of requestContext
+// instead of ctx. Cached responses are returned even if ctx is done.
+func (c *Connection) fetch(ctx context.Context, key string, fn func(ctx context.Context) (interface{}, error)) (*response, error) {
+	if out, found := c.cache.Get(key); found {
+		c.stats.cacheRequest(key, cacheHit)
+		return out.(*response), nil
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	executed := false
+	ch := c.group.DoChan(key, func() (interface{}, error) {
+		executed = true
+		// the request is shared with the coalesced callers, it doesn't end
+		// when the caller which started it gives up
+		fetchCtx, cancel := c.requestContext()
+		defer cancel()
+		out, err := fn(fetchCtx)
+		if err != nil {
+			return nil, err
+		}
+		resp := &response{value: out, time: time.Now()}
+		timeout := c.cacheTimeout
+		if t, ok := c.cacheTimeouts[key]; ok {
+			timeout = t
+		}
+		if timeout > 0 {
+			c.cache.Set(key, resp, timeout)
+		}
+		return resp, nil
+	})
+	select {
+	case res := <-ch:
+		if executed {
+			c.stats.cacheRequest(key, cacheMiss)
+		} else {
+			c.stats.cacheRequest(key, cacheCoalesced)
+		}
+		if res.Err != nil {
+			return nil, res.Err
+		}
+		return res.Val.(*response), nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// Info return the A2S_INFO response of the server
+func (c *Connection) Info(ctx context.Context) (*A2SInfo, error) {
+	info, _, err := c.InfoWithTime(ctx)
+	return info, err
+}
+
+// InfoWithTime is Info which also returns the time the response was received
+// from the server, a cached response keeps the time it was received at
+func (c *Connection) InfoWithTime(ctx context.Context) (*A2SInfo, time.Time, error) {
+	resp, err := c.fetch(ctx, "a2s:info", func(ctx context.Context) (interface{}, error) {
+		return c.a2s().Info(ctx)
+	})
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	return resp.value.(*A2SInfo), resp.time, nil
+}
+
+// Players return the A2S_PLAYER response of the server
+func (c *Connection) Players(ctx context.Context) ([]A2SPlayer, error) {
+	out, err := c.cached(ctx, "a2s:players", func(ctx context.Context) (interface{}, error) {
+		return c.a2s().Players(ctx)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return out.([]A2SPlayer), nil
+}
+
+// Rules return the A2S_RULES response of the server
+func (c *Connection) Rules(ctx context.Context) (map[string]string, error) {
+	out, err := c.cached(ctx, "a2s:rules", func(ctx context.Context) (interface{}, error) {
+		return c.a2s().Rules(ctx)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return out.(map[string]string), nil
+}
+
+// a2s returns the A2S client of the connection, RCON connections query the
+// same address as A2S is served on the game port as well
+func (c *Connection) a2s() *A2SClient {
+	if c.query != nil {
+		return c.query
+	}
+	return NewA2SClient(c.opts.Addr, c.timeout)
+}
+
+// closed returns whether the connection has been closed
+func (c *Connection) closed() bool {
+	select {
+	case <-c.done:
+		return true
+	default:
+		return false
 	}
-	return out.(string), nil
 }
 
 // Close closes a single connection
 func (c *Connection) Close() {
-	c.con.Close()
+	c.closeOnce.Do(func() {
+		close(c.done)
+	})
+	c.cmu.Lock()
+	defer c.cmu.Unlock()
+	if c.con != nil {
+		c.con.Close()
+		c.con = nil
+	}
+	c.mu.Lock()
+	c.sessionCreated = time.Time{}
+	c.mu.Unlock()
 }
@@ -0,0 +1,510 @@
+/*
+Copyright 2020 Alexander Trost <galexrt@googlemail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package connector
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/galexrt/srcds_exporter/connector/rcontest"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestServer(t *testing.T) *rcontest.Server {
+	s, err := rcontest.NewServer("secret")
+	require.NoError(t, err)
+	t.Cleanup(func() { s.Close() })
+	s.Handle("status", "hostname: Test Server\n")
+	return s
+}
+
+func newTestConnection(t *testing.T, opts *ConnectionOptions) *Connection {
+	if opts.ConnectTimeout == "" {
+		opts.ConnectTimeout = "1s"
+	}
+	if opts.CacheTimeout == "" {
+		opts.CacheTimeout = "0s"
+	}
+	opts.CommandCacheTimeouts = map[string]string{"status": "0s"}
+	con, err := newConnection("test", opts)
+	require.NoError(t, err)
+	t.Cleanup(con.Close)
+	con.start()
+	return con
+}
+
+func TestConnectionGet(t *testing.T) {
+	s := newTestServer(t)
+	con := newTestConnection(t, &ConnectionOptions{
+		Addr:         s.Addr,
+		RconPassword: "secret",
+	})
+	waitForState(t, con, StateHealthy)
+
+	for i := 0; i < 3; i++ {
+		out, err := con.Get(context.Background(), "status")
+		require.NoError(t, err)
+		assert.Equal(t, "hostname: Test Server\n", out)
+	}
+	// the session is reused for all commands
+	assert.Equal(t, 1, s.Connections())
+	assert.True(t, con.SessionAge() > 0)
+}
+
+func TestConnectionAuthFailed(t *testing.T) {
+	s := newTestServer(t)
+	s.SetMaxFailures(3)
+	con := newTestConnection(t, &ConnectionOptions{
+		Addr:         s.Addr,
+		RconPassword: "wrong",
+	})
+	waitForState(t, con, StateAuthFailed)
+
+	_, err := con.Get(context.Background(), "status")
+	assert.True(t, errors.Is(err, ErrAuthFailed))
+	time.Sleep(100 * time.Millisecond)
+	assert.Equal(t, 1, s.AuthAttempts())
+	assert.False(t, s.Banned())
+}
+
+func TestConnectionReconnect(t *testing.T) {
+	s := newTestServer(t)
+	con := newTestConnection(t, &ConnectionOptions{
+		Addr:         s.Addr,
+		RconPassword: "secret",
+	})
+	waitForState(t, con, StateHealthy)
+	_, err := con.Get(context.Background(), "status")
+	require.NoError(t, err)
+
+	s.DropConnections()
+	_, err = con.Get(context.Background(), "status")
+	assert.Error(t, err)
+
+	waitForState(t, con, StateHealthy)
+	_, err = con.Get(context.Background(), "status")
+	require.NoError(t, err)
+	assert.Equal(t, 2, s.Connections())
+	assert.Equal(t, float64(1), con.stats.reconnects)
+}
+
+func TestConnectionKeepalive(t *testing.T) {
+	s := newTestServer(t)
+	con := newTestConnection(t, &ConnectionOptions{
+		Addr:              s.Addr,
+		RconPassword:      "secret",
+		KeepaliveInterval: "20ms",
+	})
+	waitForState(t, con, StateHealthy)
+
+	s.DropConnections()
+	deadline := time.Now().Add(5 * time.Second)
+	for s.Connections() < 2 {
+		require.True(t, time.Now().Before(deadline), "keepalive didn't reconnect")
+		time.Sleep(10 * time.Millisecond)
+	}
+	waitForState(t, con, StateHealthy)
+	// the new session is probed as well
+	for len(s.Commands()) == 0 {
+		require.True(t, time.Now().Before(deadline), "keepalive didn't probe the session")
+		time.Sleep(10 * time.Millisecond)
+	}
+	assert.Equal(t, "", s.Commands()[0])
+}
+
+func TestConnectionMaxSessionAge(t *testing.T) {
+	s := newTestServer(t)
+	con := newTestConnection(t, &ConnectionOptions{
+		Addr:          s.Addr,
+		RconPassword:  "secret",
+		MaxSessionAge: "50ms",
+	})
+	waitForState(t, con, StateHealthy)
+
+	time.Sleep(100 * time.Millisecond)
+	_, err := con.Get(context.Background(), "status")
+	require.NoError(t, err)
+	assert.Equal(t, 2, s.Connections())
+}
+
+func TestConnectionTelemetry(t *testing.T) {
+	s := newTestServer(t)
+	con := newTestConnection(t, &ConnectionOptions{
+		Addr:           s.Addr,
+		RconPassword:   "secret",
+		ConnectTimeout: "100ms",
+	})
+	waitForState(t, con, StateHealthy)
+
+	_, err := con.Get(context.Background(), "status")
+	require.NoError(t, err)
+	s.SetDelay(300 * time.Millisecond)
+	_, err = con.Get(context.Background(), "status")
+	assert.Error(t, err)
+
+	con.stats.mu.Lock()
+	defer con.stats.mu.Unlock()
+	r := con.stats.requests["status"]
+	require.NotNil(t, r)
+	assert.Equal(t, uint64(2), r.count)
+	assert.Equal(t, float64(12), r.sentBytes)
+	assert.Equal(t, float64(len("hostname: Test Server\n")), r.receivedBytes)
+	assert.Equal(t, float64(1), con.stats.errors[errorKey{command: "status", typ: errorTimeout}])
+}
+
+func TestConnectionConnectError(t *testing.T) {
+	s := newTestServer(t)
+	addr := s.Addr
+	s.Close()
+	con := newTestConnection(t, &ConnectionOptions{
+		Addr:         addr,
+		RconPassword: "secret",
+	})
+	waitForState(t, con, StateBackoff)
+
+	// failed connection attempts are counted under their own command
+	con.stats.mu.Lock()
+	defer con.stats.mu.Unlock()
+	assert.Equal(t, float64(1), con.stats.errors[errorKey{command: connectCommand, typ: errorRefused}])
+	assert.Zero(t, con.stats.errors[errorKey{command: "", typ: errorRefused}])
+}
+
+func TestConnectionGetContext(t *testing.T) {
+	s := newTestServer(t)
+	con := newTestConnection(t, &ConnectionOptions{
+		Addr:           s.Addr,
+		RconPassword:   "secret",
+		ConnectTimeout: "5s",
+	})
+	waitForState(t, con, StateHealthy)
+
+	s.SetDelay(2 * time.Second)
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	begin := time.Now()
+	_, err := con.Get(ctx, "status")
+	assert.True(t, errors.Is(err, context.DeadlineExceeded), "%v", err)
+	assert.True(t, time.Since(begin) < time.Second, "Get didn't return at the deadline")
+}
+
+func TestConnectionGetCoalescedContext(t *testing.T) {
+	s := newTestServer(t)
+	con := newTestConnection(t, &ConnectionOptions{
+		Addr:           s.Addr,
+		RconPassword:   "secret",
+		ConnectTimeout: "5s",
+	})
+	waitForState(t, con, StateHealthy)
+
+	s.SetDelay(200 * time.Millisecond)
+	// the caller which started the request gives up, the coalesced caller
+	// still gets the response
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	first := make(chan error)
+	go func() {
+		_, err := con.Get(ctx, "status")
+		first <- err
+	}()
+	time.Sleep(10 * time.Millisecond)
+	out, err := con.Get(context.Background(), "status")
+	require.NoError(t, err)
+	assert.Equal(t, "hostname: Test Server\n", out)
+	assert.True(t, errors.Is(<-first, context.DeadlineExceeded))
+
+	// the session survived the caller giving up
+	assert.Equal(t, StateHealthy, con.State())
+	assert.Equal(t, 1, s.Connections())
+}
+
+func TestConnectionTimeoutKeepsSession(t *testing.T) {
+	s := newTestServer(t)
+	con := newTestConnection(t, &ConnectionOptions{
+		Addr:           s.Addr,
+		RconPassword:   "secret",
+		ConnectTimeout: "100ms",
+	})
+	waitForState(t, con, StateHealthy)
+
+	s.SetDelay(200 * time.Millisecond)
+	_, err := con.Get(context.Background(), "status")
+	assert.True(t, errors.Is(err, context.DeadlineExceeded), "%v", err)
+	s.SetDelay(0)
+	// the late response of the timed out request is skipped
+	time.Sleep(200 * time.Millisecond)
+	out, err := con.Get(context.Background(), "status")
+	require.NoError(t, err)
+	assert.Equal(t, "hostname: Test Server\n", out)
+	assert.Equal(t, 1, s.Connections())
+}
+
+func TestConnectionUpdatePassword(t *testing.T) {
+	s := newTestServer(t)
+	cn := NewConnector()
+	t.Cleanup(cn.CloseAll)
+	_, err := cn.Reload(map[string]*ConnectionOptions{
+		"test": {
+			Addr:           s.Addr,
+			RconPassword:   "old",
+			ConnectTimeout: "1s",
+			CacheTimeout:   "0s",
+		},
+	})
+	require.NoError(t, err)
+	con := cn.connections["test"]
+	waitForState(t, con, StateAuthFailed)
+
+	changed, err := cn.UpdatePassword("test", "secret")
+	require.NoError(t, err)
+	assert.True(t, changed)
+	waitForState(t, con, StateHealthy)
+	_, err = con.Get(context.Background(), "status")
+	require.NoError(t, err)
+
+	changed, err = cn.UpdatePassword("test", "secret")
+	require.NoError(t, err)
+	assert.False(t, changed)
+	_, err = cn.UpdatePassword("unknown", "secret")
+	assert.Error(t, err)
+}
+
+func TestConnectionPasswordUnavailable(t *testing.T) {
+	s := newTestServer(t)
+	cn := NewConnector()
+	t.Cleanup(cn.CloseAll)
+	_, err := cn.Reload(map[string]*ConnectionOptions{
+		"test": {
+			Addr:                s.Addr,
+			PasswordUnavailable: true,
+			ConnectTimeout:      "1s",
+			CacheTimeout:        "0s",
+		},
+	})
+	require.NoError(t, err)
+	con := cn.connections["test"]
+	assert.Equal(t, StateSecretFailed, con.State())
+	assert.True(t, errors.Is(con.LastError(), ErrPasswordUnavailable))
+	_, err = con.Get(context.Background(), "status")
+	assert.True(t, errors.Is(err, ErrNotConnected), "%v", err)
+	assert.Equal(t, 0, s.Connections())
+
+	_, ok := cn.Password("test")
+	assert.False(t, ok)
+
+	changed, err := cn.UpdatePassword("test", "secret")
+	require.NoError(t, err)
+	assert.True(t, changed)
+	waitForState(t, con, StateHealthy)
+	_, err = con.Get(context.Background(), "status")
+	require.NoError(t, err)
+
+	// reloading with the password kept keeps the connection
+	password, ok := cn.Password("test")
+	require.True(t, ok)
+	result, err := cn.Reload(map[string]*ConnectionOptions{
+		"test": {
+			Addr:           s.Addr,
+			RconPassword:   password,
+			ConnectTimeout: "1s",
+			CacheTimeout:   "0s",
+		},
+	})
+	require.NoError(t, err)
+	assert.Empty(t, result.Changed)
+	assert.Equal(t, StateHealthy, con.State())
+}
+
+// blockingTransport is returned by a dial which blocks until it is released
+type blockingTransport struct {
+	closed chan struct{}
+}
+
+func (t *blockingTransport) Send(ctx context.Context, cmd string) (string, error) {
+	return "", nil
+}
+
+func (t *blockingTransport) Health() error {
+	return nil
+}
+
+func (t *blockingTransport) Close() error {
+	close(t.closed)
+	return nil
+}
+
+func TestConnectionCloseWhileDialing(t *testing.T) {
+	dialing := make(chan struct{})
+	release := make(chan struct{})
+	transport := &blockingTransport{closed: make(chan struct{})}
+	Transports["blocking"] = func(opts *ConnectionOptions) (Transport, error) {
+		close(dialing)
+		<-release
+		return transport, nil
+	}
+	t.Cleanup(func() { delete(Transports, "blocking") })
+
+	con, err := newConnection("test", &ConnectionOptions{
+		Addr:           "blocking",
+		ConnectTimeout: "1s",
+		CacheTimeout:   "0s",
+		Protocol:       "blocking",
+	})
+	require.NoError(t, err)
+	con.start()
+	<-dialing
+	// Close waits for the dial holding the transport lock
+	closed := make(chan struct{})
+	go func() {
+		con.Close()
+		close(closed)
+	}()
+	waitForClosed(t, con)
+	close(release)
+	<-closed
+
+	// the session dialed for the removed server is closed instead of stored
+	select {
+	case <-transport.closed:
+	case <-time.After(5 * time.Second):
+		t.Fatal("transport dialed after Close wasn't closed")
+	}
+	con.cmu.Lock()
+	assert.Nil(t, con.con)
+	con.cmu.Unlock()
+	assert.NotEqual(t, StateHealthy, con.State())
+}
+
+func TestConnectorCollectWhileDialing(t *testing.T) {
+	dialing := make(chan struct{})
+	release := make(chan struct{})
+	Transports["blocking"] = func(opts *ConnectionOptions) (Transport, error) {
+		close(dialing)
+		<-release
+		return &blockingTransport{closed: make(chan struct{})}, nil
+	}
+	t.Cleanup(func() { delete(Transports, "blocking") })
+
+	cn := NewConnector()
+	t.Cleanup(cn.CloseAll)
+	_, err := cn.Reload(map[string]*ConnectionOptions{
+		"test": {
+			Addr:           "blocking",
+			ConnectTimeout: "1s",
+			CacheTimeout:   "0s",
+			Protocol:       "blocking",
+		},
+	})
+	require.NoError(t, err)
+	<-dialing
+	defer close(release)
+
+	// the metrics don't wait for the dial holding the transport lock
+	collected := make(chan struct{})
+	go func() {
+		cn.Collect(make(chan prometheus.Metric, 100))
+		close(collected)
+	}()
+	select {
+	case <-collected:
+	case <-time.After(time.Second):
+		t.Fatal("Collect blocked while the server was dialed")
+	}
+}
+
+// waitForClosed waits until Close has been called for the connection
+func waitForClosed(t *testing.T, con *Connection) {
+	deadline := time.Now().Add(5 * time.Second)
+	for !con.closed() {
+		require.True(t, time.Now().Before(deadline), "connection not closed")
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestConnectionCacheTimeout(t *testing.T) {
+	tests := []struct {
+		name         string
+		cacheTimeout string
+		commands     map[string]string
+		requests     int
+	}{
+		{name: "cached", cacheTimeout: "1m", requests: 1},
+		{name: "zero disables caching", cacheTimeout: "0s", requests: 3},
+		{name: "command override disables caching", cacheTimeout: "1m", commands: map[string]string{"status": "0s"}, requests: 3},
+		{name: "command override enables caching", cacheTimeout: "0s", commands: map[string]string{"status": "1m"}, requests: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestServer(t)
+			con, err := newConnection("test", &ConnectionOptions{
+				Addr:                 s.Addr,
+				RconPassword:         "secret",
+				ConnectTimeout:       "1s",
+				CacheTimeout:         tt.cacheTimeout,
+				CommandCacheTimeouts: tt.commands,
+			})
+			require.NoError(t, err)
+			t.Cleanup(con.Close)
+			con.start()
+			waitForState(t, con, StateHealthy)
+
+			for i := 0; i < 3; i++ {
+				_, err := con.Get(context.Background(), "status")
+				require.NoError(t, err)
+			}
+			assert.Equal(t, tt.requests, countCommands(s.Commands(), "status"))
+		})
+	}
+}
+
+func TestConnectionGetWithTime(t *testing.T) {
+	s := newTestServer(t)
+	con, err := newConnection("test", &ConnectionOptions{
+		Addr:           s.Addr,
+		RconPassword:   "secret",
+		ConnectTimeout: "1s",
+		CacheTimeout:   "1m",
+	})
+	require.NoError(t, err)
+	t.Cleanup(con.Close)
+	con.start()
+	waitForState(t, con, StateHealthy)
+
+	before := time.Now()
+	_, fetched, err := con.GetWithTime(context.Background(), "status")
+	require.NoError(t, err)
+	assert.False(t, fetched.Before(before))
+	// the cached response keeps the time it was received at
+	time.Sleep(10 * time.Millisecond)
+	_, cached, err := con.GetWithTime(context.Background(), "status")
+	require.NoError(t, err)
+	assert.Equal(t, fetched, cached)
+}
+
+// countCommands counts how often cmd was sent to the server
+func countCommands(commands []string, cmd string) int {
+	n := 0
+	for _, c := range commands {
+		if c == cmd {
+			n++
+		}
+	}
+	return n
+}
@@ -18,17 +18,29 @@ package connector
 
 import (
 	"fmt"
+	"reflect"
+	"sort"
+	"sync"
 	"time"
 
-	rcon "github.com/galexrt/go-rcon"
 	cache "github.com/patrickmn/go-cache"
 )
 
 // Connector struct contains the connections
 type Connector struct {
+	mu          sync.RWMutex
 	connections map[string]*Connection
 }
 
+// ReloadResult lists the server names affected by a Reload
+type ReloadResult struct {
+	Added   []string
+	Removed []string
+	Changed []string
+	// Renamed maps old to new server names
+	Renamed map[string]string
+}
+
 // NewConnector creates a new Connector object
 func NewConnector() *Connector {
 	return &Connector{
@@ -36,54 +48,278 @@ func NewConnector() *Connector {
 	}
 }
 
-// GetConnections holds all connections and reconnects/reopens them if necessary
+// GetConnections returns a snapshot of all connections by server name
 func (cn *Connector) GetConnections() (map[string]*Connection, error) {
-	return cn.connections, nil
+	cn.mu.RLock()
+	defer cn.mu.RUnlock()
+	connections := make(map[string]*Connection, len(cn.connections))
+	for name, con := range cn.connections {
+		connections[name] = con
+	}
+	return connections, nil
 }
 
-// NewConnection Add a new connection and initiates first contact connection
+// NewConnection Add a new connection and initiates first contact connection in
+// the background, connection errors are retried with an exponential backoff
 func (cn *Connector) NewConnection(name string, opts *ConnectionOptions) error {
-	if _, ok := cn.connections[opts.Addr]; ok {
+	cn.mu.Lock()
+	defer cn.mu.Unlock()
+	if _, ok := cn.connections[name]; ok {
 		return nil
 	}
-	con, err := rcon.Connect(opts.Addr,
-		&rcon.ConnectOptions{
-			RCONPassword: opts.RconPassword,
-			Timeout:      opts.ConnectTimeout,
-		})
+	con, err := newConnection(name, opts)
 	if err != nil {
 		return err
 	}
-	var (
-		conTimeoutParsed   time.Duration
-		cacheTimeoutParsed time.Duration
-	)
-	conTimeoutParsed, err = time.ParseDuration(opts.ConnectTimeout)
-	if err != nil {
-		return err
+	cn.connections[name] = con
+	con.start()
+	return nil
+}
+
+// Reload replaces the connections with the given servers. New servers are
+// added, removed servers are closed and servers with changed options are
+// reconnected. A server which only changed its name keeps its connection.
+// If any of the options are invalid no connection is changed at all.
+func (cn *Connector) Reload(servers map[string]*ConnectionOptions) (*ReloadResult, error) {
+	normalized := make(map[string]ConnectionOptions, len(servers))
+	for name, opts := range servers {
+		o, err := normalizeOptions(name, opts)
+		if err != nil {
+			return nil, err
+		}
+		normalized[name] = o
+	}
+
+	cn.mu.Lock()
+	defer cn.mu.Unlock()
+
+	result := &ReloadResult{
+		Renamed: map[string]string{},
+	}
+	connections := make(map[string]*Connection, len(normalized))
+	var removed []*Connection
+	for name, con := range cn.connections {
+		opts, ok := normalized[name]
+		if !ok {
+			removed = append(removed, con)
+			continue
+		}
+		if !reflect.DeepEqual(opts, con.opts) {
+			result.Changed = append(result.Changed, name)
+			removed = append(removed, con)
+			continue
+		}
+		connections[name] = con
+	}
+
+	var added []string
+	for name := range normalized {
+		if _, ok := connections[name]; ok {
+			continue
+		}
+		if _, ok := cn.connections[name]; ok {
+			// changed, reconnected below
+			continue
+		}
+		added = append(added, name)
+	}
+	sort.Strings(added)
+
+	var closing []*Connection
+	for _, con := range removed {
+		if _, ok := normalized[con.Name()]; ok {
+			// changed server, the new connection is created below
+			closing = append(closing, con)
+			continue
+		}
+		renamed := false
+		for i, name := range added {
+			if reflect.DeepEqual(normalized[name], con.opts) {
+				result.Renamed[con.Name()] = name
+				con.rename(name)
+				connections[name] = con
+				added = append(added[:i], added[i+1:]...)
+				renamed = true
+				break
+			}
+		}
+		if !renamed {
+			result.Removed = append(result.Removed, con.Name())
+			closing = append(closing, con)
+		}
+	}
+	result.Added = added
+
+	var created []*Connection
+	for _, name := range append(append([]string{}, added...), result.Changed...) {
+		opts := normalized[name]
+		con, err := newConnection(name, &opts)
+		if err != nil {
+			return nil, err
+		}
+		connections[name] = con
+		created = append(created, con)
+	}
+
+	cn.connections = connections
+	for _, con := range closing {
+		con.Close()
+	}
+	for _, con := range created {
+		con.start()
+	}
+	sort.Strings(result.Removed)
+	sort.Strings(result.Changed)
+	return result, nil
+}
+
+// normalizeOptions validates the options and fills in defaults
+func normalizeOptions(name string, opts *ConnectionOptions) (ConnectionOptions, error) {
+	o := *opts
+	if o.Protocol == "" {
+		o.Protocol = ProtocolRCON
+	}
+	if _, ok := Transports[o.Protocol]; !ok && o.Protocol != ProtocolA2S {
+		return o, fmt.Errorf("unknown protocol '%s' for server %s", o.Protocol, name)
+	}
+	if _, err := time.ParseDuration(o.ConnectTimeout); err != nil {
+		return o, fmt.Errorf("invalid rcon timeout for server %s. %+v", name, err)
+	}
+	if _, err := time.ParseDuration(o.CacheTimeout); err != nil {
+		return o, fmt.Errorf("invalid cache timeout for server %s. %+v", name, err)
+	}
+	if o.KeepaliveInterval == "" {
+		o.KeepaliveInterval = DefaultKeepaliveInterval
+	}
+	if keepalive, err := time.ParseDuration(o.KeepaliveInterval); err != nil || keepalive <= 0 {
+		return o, fmt.Errorf("invalid keepalive interval '%s' for server %s", o.KeepaliveInterval, name)
 	}
-	cacheTimeoutParsed, err = time.ParseDuration(opts.CacheTimeout)
+	if o.MaxSessionAge != "" {
+		if _, err := time.ParseDuration(o.MaxSessionAge); err != nil {
+			return o, fmt.Errorf("invalid max session age for server %s. %+v", name, err)
+		}
+	}
+	if o.AuthFailureBudget == 0 {
+		o.AuthFailureBudget = 1
+	}
+	if o.AuthFailureBudget < 0 {
+		return o, fmt.Errorf("invalid auth failure budget %d for server %s", o.AuthFailureBudget, name)
+	}
+	if o.AuthFailureWindow == "" {
+		o.AuthFailureWindow = DefaultAuthFailureWindow
+	}
+	if _, err := time.ParseDuration(o.AuthFailureWindow); err != nil {
+		return o, fmt.Errorf("invalid auth failure window for server %s. %+v", name, err)
+	}
+	for cmd, timeout := range o.CommandCacheTimeouts {
+		if _, err := time.ParseDuration(timeout); err != nil {
+			return o, fmt.Errorf("invalid cache timeout for command %s of server %s. %+v", cmd, name, err)
+		}
+	}
+	if o.PollInterval != "" {
+		if interval, err := time.ParseDuration(o.PollInterval); err != nil || interval < 0 {
+			return o, fmt.Errorf("invalid poll interval '%s' for server %s", o.PollInterval, name)
+		}
+	}
+	if o.PollJitter != "" {
+		if jitter, err := time.ParseDuration(o.PollJitter); err != nil || jitter < 0 {
+			return o, fmt.Errorf("invalid poll jitter '%s' for server %s", o.PollJitter, name)
+		}
+	}
+	if o.PacketGap != "" {
+		if gap, err := time.ParseDuration(o.PacketGap); err != nil || gap < 0 {
+			return o, fmt.Errorf("invalid packet gap '%s' for server %s", o.PacketGap, name)
+		}
+	}
+	if len(o.CommandCacheTimeouts) == 0 {
+		o.CommandCacheTimeouts = nil
+	}
+	if len(o.Collectors) == 0 {
+		o.Collectors = nil
+	}
+	if len(o.Labels) == 0 {
+		o.Labels = nil
+	}
+	return o, nil
+}
+
+func newConnection(name string, opts *ConnectionOptions) (*Connection, error) {
+	o, err := normalizeOptions(name, opts)
 	if err != nil {
-		return err
+		return nil, err
 	}
-	fmt.Print(cacheTimeoutParsed)
-	// TODO make cache time configurable?
-	cn.connections[opts.Addr] = &Connection{
-		Name:  name,
-		con:   con,
-		cache: *cache.New(cacheTimeoutParsed, 11*time.Second),
-		opts: map[string]string{
-			"Address":      opts.Addr,
-			"RCONPassword": opts.RconPassword,
-			"Timeout":      opts.ConnectTimeout,
-		},
-		created: time.Now().Add(conTimeoutParsed),
+	conTimeoutParsed, _ := time.ParseDuration(o.ConnectTimeout)
+	cacheTimeoutParsed, _ := time.ParseDuration(o.CacheTimeout)
+	con := &Connection{
+		name:    name,
+		done:    make(chan struct{}),
+		cache:   *cache.New(cache.NoExpiration, 11*time.Second),
+		stats:   newConnectionStats(),
+		opts:    o,
+		timeout: conTimeoutParsed,
+
+		cacheTimeout:  cacheTimeoutParsed,
+		cacheTimeouts: map[string]time.Duration{},
 	}
-	return nil
+	for cmd, timeout := range o.CommandCacheTimeouts {
+		con.cacheTimeouts[cmd], _ = time.ParseDuration(timeout)
+	}
+	con.keepalive, _ = time.ParseDuration(o.KeepaliveInterval)
+	con.authWindow, _ = time.ParseDuration(o.AuthFailureWindow)
+	if o.MaxSessionAge != "" {
+		con.maxSessionAge, _ = time.ParseDuration(o.MaxSessionAge)
+	}
+	if o.PollInterval != "" {
+		con.pollInterval, _ = time.ParseDuration(o.PollInterval)
+		con.pollJitter = con.pollInterval / 10
+	}
+	if o.PollJitter != "" {
+		con.pollJitter, _ = time.ParseDuration(o.PollJitter)
+	}
+	if o.Protocol == ProtocolA2S {
+		// A2S is connectionless, there is no session to establish
+		con.query = NewA2SClient(o.Addr, conTimeoutParsed)
+		con.state = StateHealthy
+	} else if o.PasswordUnavailable {
+		con.state = StateSecretFailed
+		con.lastErr = ErrPasswordUnavailable
+	}
+	return con, nil
+}
+
+// UpdatePassword changes the RCON password of a server without reopening its
+// session, returns whether the password changed
+func (cn *Connector) UpdatePassword(name string, password string) (bool, error) {
+	cn.mu.Lock()
+	defer cn.mu.Unlock()
+	con, ok := cn.connections[name]
+	if !ok {
+		return false, fmt.Errorf("connector: server %s not found", name)
+	}
+	return con.setPassword(password), nil
+}
+
+// Password returns the RCON password used for new sessions of a server, ok is
+// false if the server doesn't exist or its password is unavailable
+func (cn *Connector) Password(name string) (string, bool) {
+	cn.mu.RLock()
+	defer cn.mu.RUnlock()
+	con, ok := cn.connections[name]
+	if !ok {
+		return "", false
+	}
+	con.mu.Lock()
+	defer con.mu.Unlock()
+	if con.opts.PasswordUnavailable {
+		return "", false
+	}
+	return con.opts.RconPassword, true
 }
 
 // CloseAll closes all open connections
 func (cn *Connector) CloseAll() {
+	cn.mu.Lock()
+	defer cn.mu.Unlock()
 	for _, con := range cn.connections {
 		con.Close()
 	}
@@ -0,0 +1,372 @@
+/*
+Copyright 2020 Alexander Trost <galexrt@googlemail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package connector
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBackoff(t *testing.T) {
+	b := &backoff{}
+	last := time.Duration(0)
+	for i := 0; i < 20; i++ {
+		delay := b.next()
+		assert.True(t, delay <= time.Duration(float64(backoffMax)*(1+backoffJitter)))
+		if i < 4 {
+			assert.True(t, delay > last, "delay should grow")
+		}
+		last = delay
+	}
+	assert.True(t, last >= time.Duration(float64(backoffMax)*(1-backoffJitter)))
+
+	b.reset()
+	assert.True(t, b.next() <= time.Duration(float64(backoffMin)*(1+backoffJitter)))
+}
+
+// unusedAddr returns a local address nothing is listening on
+func unusedAddr(t *testing.T) string {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	addr := l.Addr().String()
+	l.Close()
+	return addr
+}
+
+func waitForState(t *testing.T, con *Connection, state State) {
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		if con.State() == state {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("connection %s did not reach state %s, current state %s", con.Name(), state, con.State())
+}
+
+func TestNewConnectionUnreachable(t *testing.T) {
+	cn := NewConnector()
+	defer cn.CloseAll()
+
+	addr := unusedAddr(t)
+	require.NoError(t, cn.NewConnection("test", &ConnectionOptions{
+		Addr:           addr,
+		RconPassword:   "secret",
+		ConnectTimeout: "1s",
+		CacheTimeout:   "1s",
+	}))
+	connections, err := cn.GetConnections()
+	require.NoError(t, err)
+	con := connections["test"]
+	require.NotNil(t, con)
+
+	waitForState(t, con, StateBackoff)
+	assert.Error(t, con.LastError())
+
+	_, err = con.Get(context.Background(), "status")
+	assert.True(t, errors.Is(err, ErrNotConnected))
+}
+
+func TestNewConnectionInvalidOptions(t *testing.T) {
+	cn := NewConnector()
+	defer cn.CloseAll()
+
+	assert.Error(t, cn.NewConnection("test", &ConnectionOptions{
+		Addr:           "127.0.0.1:27015",
+		ConnectTimeout: "1s",
+		CacheTimeout:   "1s",
+		Protocol:       "telnet",
+	}))
+	assert.Error(t, cn.NewConnection("test", &ConnectionOptions{
+		Addr:           "127.0.0.1:27015",
+		ConnectTimeout: "soon",
+		CacheTimeout:   "1s",
+	}))
+	assert.Error(t, cn.NewConnection("test", &ConnectionOptions{
+		Addr:              "127.0.0.1:27015",
+		ConnectTimeout:    "1s",
+		CacheTimeout:      "1s",
+		KeepaliveInterval: "0s",
+	}))
+	assert.Error(t, cn.NewConnection("test", &ConnectionOptions{
+		Addr:           "127.0.0.1:27015",
+		ConnectTimeout: "1s",
+		CacheTimeout:   "1s",
+		MaxSessionAge:  "forever",
+	}))
+}
+
+func reloadOptions(addr string, password string) *ConnectionOptions {
+	return &ConnectionOptions{
+		Addr:           addr,
+		RconPassword:   password,
+		ConnectTimeout: "1s",
+		CacheTimeout:   "1s",
+	}
+}
+
+func TestReload(t *testing.T) {
+	cn := NewConnector()
+	defer cn.CloseAll()
+
+	addr1, addr2, addr3 := unusedAddr(t), unusedAddr(t), unusedAddr(t)
+	result, err := cn.Reload(map[string]*ConnectionOptions{
+		"server1": reloadOptions(addr1, "secret"),
+		"server2": reloadOptions(addr2, "secret"),
+		"server3": reloadOptions(addr3, "secret"),
+	})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"server1", "server2", "server3"}, result.Added)
+	before, _ := cn.GetConnections()
+
+	result, err = cn.Reload(map[string]*ConnectionOptions{
+		"server1":         reloadOptions(addr1, "secret"),
+		"server2":         reloadOptions(addr2, "changed"),
+		"server3-renamed": reloadOptions(addr3, "secret"),
+		"server4":         reloadOptions(unusedAddr(t), "secret"),
+	})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"server4"}, result.Added)
+	assert.Empty(t, result.Removed)
+	assert.Equal(t, []string{"server2"}, result.Changed)
+	assert.Equal(t, map[string]string{"server3": "server3-renamed"}, result.Renamed)
+
+	after, _ := cn.GetConnections()
+	assert.Len(t, after, 4)
+	assert.Same(t, before["server1"], after["server1"])
+	assert.NotSame(t, before["server2"], after["server2"])
+	assert.Same(t, before["server3"], after["server3-renamed"])
+	assert.Equal(t, "server3-renamed", after["server3-renamed"].Name())
+	for name, con := range after {
+		assert.Equal(t, name, con.Name())
+	}
+	select {
+	case <-before["server2"].done:
+	default:
+		t.Error("connection of changed server has not been closed")
+	}
+
+	result, err = cn.Reload(map[string]*ConnectionOptions{
+		"server1": reloadOptions(addr1, "secret"),
+	})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"server2", "server3-renamed", "server4"}, result.Removed)
+	final, _ := cn.GetConnections()
+	assert.Len(t, final, 1)
+	for _, name := range result.Removed {
+		select {
+		case <-after[name].done:
+		default:
+			t.Errorf("connection of removed server %s has not been closed", name)
+		}
+	}
+}
+
+func TestReloadInvalid(t *testing.T) {
+	cn := NewConnector()
+	defer cn.CloseAll()
+
+	_, err := cn.Reload(map[string]*ConnectionOptions{
+		"server1": reloadOptions(unusedAddr(t), "secret"),
+	})
+	require.NoError(t, err)
+	before, _ := cn.GetConnections()
+
+	invalid := reloadOptions(unusedAddr(t), "secret")
+	invalid.CacheTimeout = "often"
+	_, err = cn.Reload(map[string]*ConnectionOptions{
+		"server2": invalid,
+	})
+	assert.Error(t, err)
+	after, _ := cn.GetConnections()
+	assert.Equal(t, before, after)
+
+	invalid = reloadOptions(unusedAddr(t), "secret")
+	invalid.PollInterval = "-1s"
+	_, err = cn.Reload(map[string]*ConnectionOptions{
+		"server2": invalid,
+	})
+	assert.Error(t, err)
+}
+
+func TestPollInterval(t *testing.T) {
+	con, err := newConnection("test", &ConnectionOptions{
+		Addr:           "127.0.0.1:27015",
+		ConnectTimeout: "1s",
+		CacheTimeout:   "1s",
+		Protocol:       ProtocolA2S,
+		PollInterval:   "30s",
+	})
+	require.NoError(t, err)
+	interval, jitter := con.PollInterval()
+	assert.Equal(t, 30*time.Second, interval)
+	assert.Equal(t, 3*time.Second, jitter)
+}
+
+func TestCachedCoalesces(t *testing.T) {
+	con, err := newConnection("test", &ConnectionOptions{
+		Addr:           "127.0.0.1:27015",
+		ConnectTimeout: "1s",
+		CacheTimeout:   "1m",
+		Protocol:       ProtocolA2S,
+
+		CommandCacheTimeouts: map[string]string{
+			"uncached": "0s",
+		},
+	})
+	require.NoError(t, err)
+
+	const callers = 5
+	var (
+		calls   int32
+		release = make(chan struct{})
+		started = make(chan struct{}, callers)
+		wg      sync.WaitGroup
+	)
+	fetch := func(context.Context) (interface{}, error) {
+		atomic.AddInt32(&calls, 1)
+		<-release
+		return "response", nil
+	}
+	wg.Add(callers)
+	for i := 0; i < callers; i++ {
+		go func() {
+			defer wg.Done()
+			started <- struct{}{}
+			out, err := con.cached(context.Background(), "status", fetch)
+			assert.NoError(t, err)
+			assert.Equal(t, "response", out)
+		}()
+	}
+	for i := 0; i < callers; i++ {
+		<-started
+	}
+	// give the callers time to join the in-flight request
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+	wg.Wait()
+
+	out, err := con.cached(context.Background(), "status", fetch)
+	require.NoError(t, err)
+	assert.Equal(t, "response", out)
+	assert.Equal(t, int32(1), atomic.LoadInt32(&calls))
+	assert.Equal(t, map[cacheKey]float64{
+		{command: "status", result: cacheMiss}:      1,
+		{command: "status", result: cacheCoalesced}: callers - 1,
+		{command: "status", result: cacheHit}:       1,
+	}, con.stats.cacheRequests)
+
+	for i := 0; i < 2; i++ {
+		_, err = con.cached(context.Background(), "uncached", func(context.Context) (interface{}, error) {
+			return "response", nil
+		})
+		require.NoError(t, err)
+	}
+	assert.Equal(t, float64(2), con.stats.cacheRequests[cacheKey{command: "uncached", result: cacheMiss}])
+}
+
+func TestCachedContext(t *testing.T) {
+	con, err := newConnection("test", &ConnectionOptions{
+		Addr:           "127.0.0.1:27015",
+		ConnectTimeout: "1s",
+		CacheTimeout:   "1m",
+	})
+	require.NoError(t, err)
+	defer con.Close()
+
+	release := make(chan struct{})
+	fetch := func(ctx context.Context) (interface{}, error) {
+		<-release
+		return "response", nil
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	_, err = con.cached(ctx, "status", fetch)
+	assert.True(t, errors.Is(err, context.DeadlineExceeded))
+
+	// the in-flight request still fills the cache, which is used even after
+	// the deadline
+	close(release)
+	deadline := time.Now().Add(5 * time.Second)
+	for con.cache.ItemCount() == 0 {
+		require.True(t, time.Now().Before(deadline), "response not cached")
+		time.Sleep(5 * time.Millisecond)
+	}
+	out, err := con.cached(ctx, "status", fetch)
+	require.NoError(t, err)
+	assert.Equal(t, "response", out)
+}
+
+func TestAuthFailureBudget(t *testing.T) {
+	con, err := newConnection("test", &ConnectionOptions{
+		Addr:              "127.0.0.1:27015",
+		ConnectTimeout:    "1s",
+		CacheTimeout:      "1s",
+		AuthFailureBudget: 2,
+		AuthFailureWindow: "50ms",
+	})
+	require.NoError(t, err)
+	authErr := fmt.Errorf("%w: wrong password", ErrAuthFailed)
+
+	con.mu.Lock()
+	assert.False(t, con.authFailed(authErr))
+	con.mu.Unlock()
+	time.Sleep(100 * time.Millisecond)
+
+	// the first failure is outside of the window now
+	con.mu.Lock()
+	assert.False(t, con.authFailed(authErr))
+	assert.True(t, con.authFailed(authErr))
+	con.mu.Unlock()
+
+	assert.Equal(t, StateAuthFailed, con.State())
+	assert.True(t, errors.Is(con.LastError(), ErrAuthFailed))
+	_, err = con.send(context.Background(), "status")
+	assert.True(t, errors.Is(err, ErrAuthFailed))
+	assert.Equal(t, float64(3), con.stats.authFailures)
+
+	// no new connection attempts are made
+	con.connect()
+	assert.False(t, con.connecting)
+}
+
+func TestErrorType(t *testing.T) {
+	tests := []struct {
+		err  error
+		want string
+	}{
+		{fmt.Errorf("%w: rejected", ErrAuthFailed), errorAuth},
+		{fmt.Errorf("%w (backoff)", ErrNotConnected), errorNotConnected},
+		{fmt.Errorf("%w: read", ErrTimeout), errorTimeout},
+		{&net.OpError{Op: "dial", Err: syscall.ECONNREFUSED}, errorRefused},
+		{ErrA2SChecksum, errorDecode},
+		{errors.New("boom"), errorOther},
+	}
+	for _, test := range tests {
+		assert.Equal(t, test.want, errorType(test.err), test.err.Error())
+	}
+}
@@ -0,0 +1,275 @@
+/*
+Copyright 2020 Alexander Trost <galexrt@googlemail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package connector
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+)
+
+const (
+	// DefaultGoldSrcPacketGap GoldSrc doesn't mark the end of multi-packet
+	// responses, a response is complete when no packet arrived for this long
+	DefaultGoldSrcPacketGap = "250ms"
+	// goldSrcProbe command used to check the password when dialing
+	goldSrcProbe = "echo"
+)
+
+var (
+	goldSrcHeader      = []byte{0xFF, 0xFF, 0xFF, 0xFF}
+	goldSrcSplitHeader = []byte{0xFE, 0xFF, 0xFF, 0xFF}
+)
+
+// goldSrcTransport GoldSrc (HLDS) `challenge rcon` UDP transport. Every
+// command carries the password, the challenge is requested once per session
+// and again when the server rejects it.
+type goldSrcTransport struct {
+	conn      net.Conn
+	password  string
+	timeout   time.Duration
+	packetGap time.Duration
+	challenge string
+}
+
+// DialGoldSrc opens a GoldSrc RCON Transport, the password is checked by
+// sending a probe command
+func DialGoldSrc(opts *ConnectionOptions) (Transport, error) {
+	timeout, err := time.ParseDuration(opts.ConnectTimeout)
+	if err != nil {
+		return nil, err
+	}
+	packetGap := opts.PacketGap
+	if packetGap == "" {
+		packetGap = DefaultGoldSrcPacketGap
+	}
+	gap, err := time.ParseDuration(packetGap)
+	if err != nil {
+		return nil, err
+	}
+	conn, err := net.DialTimeout("udp", opts.Addr, timeout)
+	if err != nil {
+		return nil, err
+	}
+	t := &goldSrcTransport{
+		conn:      conn,
+		password:  opts.RconPassword,
+		timeout:   timeout,
+		packetGap: gap,
+	}
+	if _, err := t.Send(context.Background(), goldSrcProbe); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return t, nil
+}
+
+// Send sends the command, the challenge is requested again if the server
+// rejects it
+func (t *goldSrcTransport) Send(ctx context.Context, cmd string) (string, error) {
+	deadline := time.Now().Add(t.timeout)
+	if d, ok := ctx.Deadline(); ok && d.Before(deadline) {
+		deadline = d
+	}
+	// the watcher is stopped before returning, so it can't interrupt the
+	// next command
+	done := make(chan struct{})
+	stopped := make(chan struct{})
+	defer func() {
+		close(done)
+		<-stopped
+	}()
+	go func() {
+		defer close(stopped)
+		select {
+		case <-ctx.Done():
+			t.conn.SetDeadline(time.Now())
+		case <-done:
+		}
+	}()
+
+	out, err := t.exchange(ctx, cmd, deadline)
+	if err != nil {
+		return "", contextError(ctx, err)
+	}
+	return out, nil
+}
+
+func (t *goldSrcTransport) exchange(ctx context.Context, cmd string, deadline time.Time) (string, error) {
+	for attempt := 0; attempt < 2; attempt++ {
+		if err := ctx.Err(); err != nil {
+			return "", err
+		}
+		if t.challenge == "" {
+			if err := t.requestChallenge(ctx, deadline); err != nil {
+				return "", err
+			}
+		}
+		out, err := t.roundTrip(ctx, fmt.Sprintf("rcon %s \"%s\" %s", t.challenge, t.password, cmd), deadline, true)
+		if err != nil {
+			return "", err
+		}
+		switch {
+		case strings.HasPrefix(out, "Bad challenge"):
+			t.challenge = ""
+			continue
+		case strings.HasPrefix(out, "Bad rcon_password"):
+			return "", fmt.Errorf("%w: %s", ErrAuthFailed, strings.TrimSpace(out))
+		case strings.HasPrefix(out, "You have been banned"):
+			return "", fmt.Errorf("%w: %s", ErrAuthFailed, strings.TrimSpace(out))
+		}
+		return out, nil
+	}
+	return "", fmt.Errorf("%w: server keeps rejecting the challenge", ErrInvalidResponse)
+}
+
+// requestChallenge requests the challenge, the response is
+// `challenge rcon <number>`
+func (t *goldSrcTransport) requestChallenge(ctx context.Context, deadline time.Time) error {
+	out, err := t.roundTrip(ctx, "challenge rcon", deadline, false)
+	if err != nil {
+		return err
+	}
+	fields := strings.Fields(out)
+	if len(fields) != 3 || fields[0] != "challenge" || fields[1] != "rcon" {
+		return fmt.Errorf("%w: unexpected challenge response %q", ErrInvalidResponse, out)
+	}
+	t.challenge = fields[2]
+	return nil
+}
+
+// roundTrip sends the payload and returns the response text, packets of
+// responses to earlier requests are discarded before sending. Multi-packet
+// responses are only waited for if multi is true, they end when no packet
+// arrived within the packet gap.
+func (t *goldSrcTransport) roundTrip(ctx context.Context, payload string, deadline time.Time, multi bool) (string, error) {
+	t.drain()
+	if err := t.conn.SetDeadline(deadline); err != nil {
+		return "", err
+	}
+	if _, err := t.conn.Write(append(append([]byte{}, goldSrcHeader...), payload...)); err != nil {
+		return "", err
+	}
+
+	var (
+		out      bytes.Buffer
+		received bool
+		split    = map[byte][]byte{}
+		buf      = make([]byte, 65535)
+	)
+	for {
+		n, err := t.conn.Read(buf)
+		if err != nil {
+			// the read was interrupted by the context, the output is partial
+			if ctxErr := ctx.Err(); ctxErr != nil {
+				return "", ctxErr
+			}
+			// the response is complete when no further packet arrives
+			var netErr net.Error
+			if errors.As(err, &netErr) && netErr.Timeout() && received && time.Now().Before(deadline) {
+				return out.String(), nil
+			}
+			return "", err
+		}
+		packet := buf[:n]
+		switch {
+		case bytes.HasPrefix(packet, goldSrcHeader):
+			text, err := goldSrcText(packet[4:])
+			if err != nil {
+				return "", err
+			}
+			out.WriteString(text)
+		case bytes.HasPrefix(packet, goldSrcSplitHeader):
+			// split header: -2, request id, packet number (high nibble
+			// index, low nibble total)
+			if len(packet) < 9 {
+				return "", fmt.Errorf("%w: short split packet", ErrInvalidResponse)
+			}
+			number := packet[8]
+			total := int(number & 0x0F)
+			split[number>>4] = append([]byte{}, packet[9:]...)
+			if len(split) < total {
+				continue
+			}
+			var data []byte
+			for i := 0; i < total; i++ {
+				data = append(data, split[byte(i)]...)
+			}
+			split = map[byte][]byte{}
+			if !bytes.HasPrefix(data, goldSrcHeader) {
+				return "", fmt.Errorf("%w: invalid split response", ErrInvalidResponse)
+			}
+			text, err := goldSrcText(data[4:])
+			if err != nil {
+				return "", err
+			}
+			out.WriteString(text)
+		default:
+			return "", fmt.Errorf("%w: unexpected packet header", ErrInvalidResponse)
+		}
+		received = true
+		if !multi || t.packetGap == 0 {
+			return out.String(), nil
+		}
+		// wait shortly for further packets of the response
+		gap := time.Now().Add(t.packetGap)
+		if gap.After(deadline) {
+			gap = deadline
+		}
+		if err := t.conn.SetReadDeadline(gap); err != nil {
+			return "", err
+		}
+	}
+}
+
+// goldSrcText returns the text of a response payload, command output is
+// prefixed with `l` (A2A_PRINT)
+func goldSrcText(payload []byte) (string, error) {
+	if len(payload) == 0 {
+		return "", fmt.Errorf("%w: empty packet", ErrInvalidResponse)
+	}
+	if payload[0] == 'l' {
+		payload = payload[1:]
+	}
+	return string(bytes.TrimRight(payload, "\x00")), nil
+}
+
+// drain discards packets which arrived after earlier requests timed out
+func (t *goldSrcTransport) drain() {
+	buf := make([]byte, 65535)
+	for {
+		if err := t.conn.SetReadDeadline(time.Now()); err != nil {
+			return
+		}
+		if _, err := t.conn.Read(buf); err != nil {
+			return
+		}
+	}
+}
+
+// Health requests a new challenge, which doesn't need the password
+func (t *goldSrcTransport) Health() error {
+	return t.requestChallenge(context.Background(), time.Now().Add(t.timeout))
+}
+
+func (t *goldSrcTransport) Close() error {
+	return t.conn.Close()
+}
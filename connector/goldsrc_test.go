@@ -0,0 +1,242 @@
+/*
+Copyright 2020 Alexander Trost <galexrt@googlemail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package connector
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"regexp"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/galexrt/srcds_exporter/parser"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const hldsStatus = `hostname:  Counter-Strike 1.6 Server
+version :  48/1.1.2.7/Stdio 8684 secure  (10)
+tcp/ip  :  10.0.0.1:27015
+map     :  de_dust2 at: 0 x, 0 y, 0 z
+players :  3 active (32 max)
+
+#      name userid uniqueid frag time ping loss adr
+# 1 "Player1"   1 STEAM_0:1:12345   5 10:24   45    0 192.168.1.10:27005
+# 2 "Player2"   2 STEAM_0:0:67890   2  5:01   60    3 192.168.1.11:27005
+# 3 "[POD]Bot"  3 BOT               0 30:43    0    0
+3 users
+`
+
+var goldSrcCommandRegex = regexp.MustCompile(`^rcon (\S+) "([^"]*)" (.*)$`)
+
+// goldSrcStub GoldSrc RCON server, responses are sent as split packets of
+// splitSize if set and as one packet per line otherwise
+type goldSrcStub struct {
+	conn      net.PacketConn
+	password  string
+	responses map[string]string
+	splitSize int
+
+	mu         sync.Mutex
+	challenge  int
+	challenges int
+	commands   []string
+}
+
+func newGoldSrcStub(t *testing.T, password string, responses map[string]string) *goldSrcStub {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+	s := &goldSrcStub{conn: conn, password: password, responses: responses, challenge: 1000}
+	go s.serve()
+	return s
+}
+
+func (s *goldSrcStub) serve() {
+	buf := make([]byte, 2048)
+	for {
+		n, addr, err := s.conn.ReadFrom(buf)
+		if err != nil {
+			return
+		}
+		req := strings.TrimRight(string(buf[4:n]), "\n\x00")
+		if req == "challenge rcon" {
+			s.mu.Lock()
+			s.challenges++
+			challenge := s.challenge
+			s.mu.Unlock()
+			s.write(addr, fmt.Sprintf("challenge rcon %d\n", challenge))
+			continue
+		}
+		m := goldSrcCommandRegex.FindStringSubmatch(req)
+		if m == nil {
+			continue
+		}
+		s.mu.Lock()
+		challenge := fmt.Sprint(s.challenge)
+		s.commands = append(s.commands, m[3])
+		splitSize := s.splitSize
+		s.mu.Unlock()
+		switch {
+		case m[1] != challenge:
+			s.write(addr, "lBad challenge.\n")
+		case m[2] != s.password:
+			s.write(addr, "lBad rcon_password.\n")
+		case splitSize > 0:
+			s.writeSplit(addr, "l"+s.responses[m[3]], splitSize)
+		default:
+			// srcds sends long output in multiple packets
+			for _, line := range strings.SplitAfter(s.responses[m[3]], "\n") {
+				if line != "" {
+					s.write(addr, "l"+line)
+				}
+			}
+		}
+	}
+}
+
+func (s *goldSrcStub) write(addr net.Addr, payload string) {
+	s.conn.WriteTo(append([]byte{0xFF, 0xFF, 0xFF, 0xFF}, payload...), addr)
+}
+
+func (s *goldSrcStub) writeSplit(addr net.Addr, payload string, size int) {
+	data := append([]byte{0xFF, 0xFF, 0xFF, 0xFF}, payload...)
+	var parts [][]byte
+	for len(data) > size {
+		parts = append(parts, data[:size])
+		data = data[size:]
+	}
+	parts = append(parts, data)
+	// send in reverse order to test reassembly
+	for i := len(parts) - 1; i >= 0; i-- {
+		header := []byte{0xFE, 0xFF, 0xFF, 0xFF, 0x01, 0x00, 0x00, 0x00, byte(i<<4 | len(parts))}
+		s.conn.WriteTo(append(header, parts[i]...), addr)
+	}
+}
+
+func (s *goldSrcStub) challengeRequests() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.challenges
+}
+
+func (s *goldSrcStub) rotateChallenge() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.challenge++
+}
+
+func dialGoldSrc(t *testing.T, s *goldSrcStub, password string, packetGap string) (Transport, error) {
+	transport, err := DialGoldSrc(&ConnectionOptions{
+		Addr:           s.conn.LocalAddr().String(),
+		RconPassword:   password,
+		ConnectTimeout: "2s",
+		PacketGap:      packetGap,
+	})
+	if err == nil {
+		t.Cleanup(func() { transport.Close() })
+	}
+	return transport, err
+}
+
+func TestGoldSrcTransport(t *testing.T) {
+	s := newGoldSrcStub(t, "secret", map[string]string{
+		"echo":   "\n",
+		"status": hldsStatus,
+	})
+	transport, err := dialGoldSrc(t, s, "secret", "")
+	require.NoError(t, err)
+
+	out, err := transport.Send(context.Background(), "status")
+	require.NoError(t, err)
+	assert.Equal(t, hldsStatus, out)
+	players, err := parser.ParsePlayers(out)
+	require.NoError(t, err)
+	assert.Len(t, players, 2)
+
+	// a rejected challenge is requested again
+	s.rotateChallenge()
+	out, err = transport.Send(context.Background(), "status")
+	require.NoError(t, err)
+	assert.Equal(t, hldsStatus, out)
+	assert.Equal(t, 2, s.challengeRequests())
+
+	assert.NoError(t, transport.Health())
+}
+
+func TestGoldSrcTransportSplit(t *testing.T) {
+	s := newGoldSrcStub(t, "secret", map[string]string{
+		"echo":   "\n",
+		"status": hldsStatus,
+	})
+	s.mu.Lock()
+	s.splitSize = 100
+	s.mu.Unlock()
+	transport, err := dialGoldSrc(t, s, "secret", "")
+	require.NoError(t, err)
+
+	out, err := transport.Send(context.Background(), "status")
+	require.NoError(t, err)
+	assert.Equal(t, hldsStatus, out)
+}
+
+func TestGoldSrcTransportAuthFailed(t *testing.T) {
+	s := newGoldSrcStub(t, "secret", map[string]string{"echo": "\n"})
+	_, err := dialGoldSrc(t, s, "wrong", "")
+	assert.True(t, errors.Is(err, ErrAuthFailed))
+}
+
+func TestGoldSrcTransportPacketGap(t *testing.T) {
+	s := newGoldSrcStub(t, "secret", map[string]string{
+		"echo":   "\n",
+		"status": hldsStatus,
+	})
+	transport, err := dialGoldSrc(t, s, "secret", "0s")
+	require.NoError(t, err)
+
+	// without a packet gap only the first packet is returned
+	out, err := transport.Send(context.Background(), "status")
+	require.NoError(t, err)
+	assert.Equal(t, strings.SplitAfter(hldsStatus, "\n")[0], out)
+}
+
+func TestGoldSrcTransportContext(t *testing.T) {
+	s := newGoldSrcStub(t, "secret", map[string]string{
+		"echo":   "\n",
+		"status": hldsStatus,
+	})
+	transport, err := dialGoldSrc(t, s, "secret", "1s")
+	require.NoError(t, err)
+
+	// the context ends while waiting for further packets, the partial
+	// output isn't returned
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	out, err := transport.Send(ctx, "status")
+	assert.True(t, errors.Is(err, context.DeadlineExceeded), "unexpected error %v", err)
+	assert.Empty(t, out)
+
+	ctx, cancel = context.WithCancel(context.Background())
+	time.AfterFunc(100*time.Millisecond, cancel)
+	out, err = transport.Send(ctx, "status")
+	assert.True(t, errors.Is(err, context.Canceled), "unexpected error %v", err)
+	assert.Empty(t, out)
+}
@@ -0,0 +1,326 @@
+/*
+Copyright 2020 Alexander Trost <galexrt@googlemail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package connector
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const namespace = "srcds"
+
+const (
+	cacheHit       = "hit"
+	cacheMiss      = "miss"
+	cacheCoalesced = "coalesced"
+)
+
+// connectCommand command label of the failed connection attempts in the rcon
+// errors metric
+const connectCommand = "connect"
+
+// Error types of the rcon errors metric
+const (
+	errorTimeout      = "timeout"
+	errorRefused      = "refused"
+	errorAuth         = "auth"
+	errorDecode       = "decode"
+	errorNotConnected = "not_connected"
+	errorOther        = "other"
+)
+
+// requestDurationBuckets buckets of the rcon request duration histogram
+var requestDurationBuckets = prometheus.DefBuckets
+
+var (
+	connectionStateDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "connection", "state"),
+		"srcds_exporter: Current state of the server connection.",
+		[]string{"server", "state"},
+		nil,
+	)
+	cacheRequestsDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "rcon", "cache_requests_total"),
+		"srcds_exporter: Requests per command by result (hit, miss or coalesced with an in-flight request).",
+		[]string{"server", "command", "result"},
+		nil,
+	)
+	reconnectsDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "rcon", "reconnects_total"),
+		"srcds_exporter: Number of times the rcon session has been reopened.",
+		[]string{"server"},
+		nil,
+	)
+	authFailedDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "rcon", "auth_failed"),
+		"srcds_exporter: Whether the server rejected the rcon password and the exporter stopped connecting.",
+		[]string{"server"},
+		nil,
+	)
+	authFailuresDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "rcon", "auth_failures_total"),
+		"srcds_exporter: Number of rejected rcon authentication attempts.",
+		[]string{"server"},
+		nil,
+	)
+	cacheHitRatioDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "rcon", "cache_hit_ratio"),
+		"srcds_exporter: Ratio of requests per command served from the cache or an in-flight request.",
+		[]string{"server", "command"},
+		nil,
+	)
+	requestDurationDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "rcon", "request_duration_seconds"),
+		"srcds_exporter: Duration of rcon commands sent to the server.",
+		[]string{"server", "command"},
+		nil,
+	)
+	sentBytesDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "rcon", "sent_bytes_total"),
+		"srcds_exporter: Bytes of rcon commands sent to the server.",
+		[]string{"server", "command"},
+		nil,
+	)
+	receivedBytesDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "rcon", "received_bytes_total"),
+		"srcds_exporter: Bytes of rcon responses received from the server.",
+		[]string{"server", "command"},
+		nil,
+	)
+	truncatedResponsesDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "rcon", "truncated_responses_total"),
+		"srcds_exporter: Rcon responses which ended before the end of the response was detected, they are discarded.",
+		[]string{"server", "command"},
+		nil,
+	)
+	errorsDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "rcon", "errors_total"),
+		"srcds_exporter: Failed rcon commands by error type, the command is \"connect\" for failed connection attempts.",
+		[]string{"server", "command", "type"},
+		nil,
+	)
+	sessionAgeDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "rcon", "session_age_seconds"),
+		"srcds_exporter: Age of the current rcon session.",
+		[]string{"server"},
+		nil,
+	)
+)
+
+type cacheKey struct {
+	command string
+	result  string
+}
+
+type errorKey struct {
+	command string
+	typ     string
+}
+
+// requestStats duration histogram and traffic of a single command
+type requestStats struct {
+	count         uint64
+	sum           float64
+	buckets       []uint64
+	sentBytes     float64
+	receivedBytes float64
+}
+
+// connectionStats counters of a single connection, they are exposed with the
+// current name of the connection so renaming a server keeps its counters
+type connectionStats struct {
+	mu            sync.Mutex
+	cacheRequests map[cacheKey]float64
+	requests      map[string]*requestStats
+	errors        map[errorKey]float64
+	truncated     map[string]float64
+	reconnects    float64
+	authFailures  float64
+}
+
+func newConnectionStats() *connectionStats {
+	return &connectionStats{
+		cacheRequests: map[cacheKey]float64{},
+		requests:      map[string]*requestStats{},
+		errors:        map[errorKey]float64{},
+		truncated:     map[string]float64{},
+	}
+}
+
+// errorType returns the error type of err for the errors metric
+func errorType(err error) string {
+	var netErr net.Error
+	switch {
+	case errors.Is(err, ErrAuthFailed):
+		return errorAuth
+	case errors.Is(err, ErrNotConnected):
+		return errorNotConnected
+	case errors.Is(err, ErrTimeout), errors.Is(err, context.DeadlineExceeded),
+		errors.As(err, &netErr) && netErr.Timeout():
+		return errorTimeout
+	case errors.Is(err, ErrConnectionRefused), errors.Is(err, syscall.ECONNREFUSED):
+		return errorRefused
+	case errors.Is(err, ErrInvalidResponse), errors.Is(err, ErrTruncatedResponse),
+		errors.Is(err, ErrA2SInvalidResponse),
+		errors.Is(err, ErrA2SChecksum):
+		return errorDecode
+	}
+	return errorOther
+}
+
+func (s *connectionStats) request(command string, duration time.Duration, sent int, received int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	r, ok := s.requests[command]
+	if !ok {
+		r = &requestStats{buckets: make([]uint64, len(requestDurationBuckets))}
+		s.requests[command] = r
+	}
+	seconds := duration.Seconds()
+	r.count++
+	r.sum += seconds
+	for i, bound := range requestDurationBuckets {
+		if seconds <= bound {
+			r.buckets[i]++
+		}
+	}
+	r.sentBytes += float64(sent)
+	r.receivedBytes += float64(received)
+}
+
+func (s *connectionStats) requestError(command string, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.errors[errorKey{command: command, typ: errorType(err)}]++
+}
+
+func (s *connectionStats) cacheRequest(command string, result string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.cacheRequests[cacheKey{command: command, result: result}]++
+}
+
+func (s *connectionStats) reconnect() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.reconnects++
+}
+
+func (s *connectionStats) authFailure() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.authFailures++
+}
+
+func (s *connectionStats) truncatedResponse(command string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.truncated[command]++
+}
+
+func (s *connectionStats) collect(ch chan<- prometheus.Metric, server string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	total := map[string]float64{}
+	hits := map[string]float64{}
+	for key, value := range s.cacheRequests {
+		ch <- prometheus.MustNewConstMetric(cacheRequestsDesc,
+			prometheus.CounterValue, value, server, key.command, key.result)
+		total[key.command] += value
+		if key.result != cacheMiss {
+			hits[key.command] += value
+		}
+	}
+	for command, value := range total {
+		ch <- prometheus.MustNewConstMetric(cacheHitRatioDesc,
+			prometheus.GaugeValue, hits[command]/value, server, command)
+	}
+	for command, r := range s.requests {
+		buckets := make(map[float64]uint64, len(requestDurationBuckets))
+		for i, bound := range requestDurationBuckets {
+			buckets[bound] = r.buckets[i]
+		}
+		ch <- prometheus.MustNewConstHistogram(requestDurationDesc,
+			r.count, r.sum, buckets, server, command)
+		ch <- prometheus.MustNewConstMetric(sentBytesDesc,
+			prometheus.CounterValue, r.sentBytes, server, command)
+		ch <- prometheus.MustNewConstMetric(receivedBytesDesc,
+			prometheus.CounterValue, r.receivedBytes, server, command)
+	}
+	for command, value := range s.truncated {
+		ch <- prometheus.MustNewConstMetric(truncatedResponsesDesc,
+			prometheus.CounterValue, value, server, command)
+	}
+	for key, value := range s.errors {
+		ch <- prometheus.MustNewConstMetric(errorsDesc,
+			prometheus.CounterValue, value, server, key.command, key.typ)
+	}
+	ch <- prometheus.MustNewConstMetric(reconnectsDesc,
+		prometheus.CounterValue, s.reconnects, server)
+	ch <- prometheus.MustNewConstMetric(authFailuresDesc,
+		prometheus.CounterValue, s.authFailures, server)
+}
+
+// Describe implements the prometheus.Collector interface.
+func (cn *Connector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- connectionStateDesc
+	ch <- cacheRequestsDesc
+	ch <- cacheHitRatioDesc
+	ch <- requestDurationDesc
+	ch <- sentBytesDesc
+	ch <- receivedBytesDesc
+	ch <- truncatedResponsesDesc
+	ch <- errorsDesc
+	ch <- reconnectsDesc
+	ch <- authFailedDesc
+	ch <- authFailuresDesc
+	ch <- sessionAgeDesc
+}
+
+// Collect implements the prometheus.Collector interface.
+func (cn *Connector) Collect(ch chan<- prometheus.Metric) {
+	connections, _ := cn.GetConnections()
+	for _, con := range connections {
+		name := con.Name()
+		current := con.State()
+		for _, state := range States {
+			var value float64
+			if state == current {
+				value = 1
+			}
+			ch <- prometheus.MustNewConstMetric(connectionStateDesc,
+				prometheus.GaugeValue, value, name, state.String())
+		}
+		con.stats.collect(ch, name)
+		if con.Protocol() != ProtocolA2S {
+			ch <- prometheus.MustNewConstMetric(sessionAgeDesc,
+				prometheus.GaugeValue, con.SessionAge().Seconds(), name)
+			var authFailed float64
+			if current == StateAuthFailed {
+				authFailed = 1
+			}
+			ch <- prometheus.MustNewConstMetric(authFailedDesc,
+				prometheus.GaugeValue, authFailed, name)
+		}
+	}
+}
@@ -0,0 +1,257 @@
+/*
+Copyright 2020 Alexander Trost <galexrt@googlemail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package connector
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"time"
+)
+
+// Packet types of the Source RCON protocol
+const (
+	rconResponseValue = 0
+	rconExecCommand   = 2
+	rconAuthResponse  = 2
+	rconAuth          = 3
+)
+
+const (
+	// rconHeaderSize size of the id and type fields and the two null bytes
+	// terminating the body
+	rconHeaderSize = 10
+	// rconMaxPacketSize srcds sends packets with bodies up to 4096 bytes, some
+	// games send larger packets
+	rconMaxPacketSize = 1 << 16
+)
+
+// rconTrailer body of the packet srcds sends after mirroring an empty
+// response value packet
+var rconTrailer = []byte{0x00, 0x01, 0x00, 0x00}
+
+// ErrTruncatedResponse the response ended before the end of the response was
+// detected, the partial response is discarded
+var ErrTruncatedResponse = errors.New("connector: truncated rcon response")
+
+// rconTransport Source RCON transport
+type rconTransport struct {
+	conn    net.Conn
+	reader  *bufio.Reader
+	timeout time.Duration
+	id      int32
+}
+
+// DialRCON opens a Source RCON Transport
+func DialRCON(opts *ConnectionOptions) (Transport, error) {
+	timeout, err := time.ParseDuration(opts.ConnectTimeout)
+	if err != nil {
+		return nil, err
+	}
+	conn, err := net.DialTimeout("tcp", opts.Addr, timeout)
+	if err != nil {
+		return nil, err
+	}
+	t := &rconTransport{
+		conn:    conn,
+		reader:  bufio.NewReader(conn),
+		timeout: timeout,
+	}
+	if err := t.auth(opts.RconPassword); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return t, nil
+}
+
+// auth authenticates the session, srcds answers with an empty response value
+// followed by the auth response which has the id -1 if the password is wrong
+func (t *rconTransport) auth(password string) error {
+	if err := t.conn.SetDeadline(time.Now().Add(t.timeout)); err != nil {
+		return err
+	}
+	id := t.nextID()
+	if err := t.write(id, rconAuth, password); err != nil {
+		return err
+	}
+	for {
+		p, err := t.read()
+		if err != nil {
+			return err
+		}
+		if p.typ != rconAuthResponse {
+			continue
+		}
+		if p.id == -1 {
+			return ErrAuthFailed
+		}
+		if p.id != id {
+			return fmt.Errorf("%w: unexpected auth response id %d", ErrInvalidResponse, p.id)
+		}
+		return nil
+	}
+}
+
+// Send sends the command followed by an empty response value packet, srcds
+// mirrors the latter after the complete (possibly multi-packet) response of
+// the command which marks its end. When ctx is done the session is closed.
+func (t *rconTransport) Send(ctx context.Context, cmd string) (string, error) {
+	deadline := time.Now().Add(t.timeout)
+	if d, ok := ctx.Deadline(); ok && d.Before(deadline) {
+		deadline = d
+	}
+	if err := t.conn.SetDeadline(deadline); err != nil {
+		return "", err
+	}
+	// the watcher is stopped before returning, so it can't close the session
+	// during the next command
+	done := make(chan struct{})
+	stopped := make(chan struct{})
+	defer func() {
+		close(done)
+		<-stopped
+	}()
+	go func() {
+		defer close(stopped)
+		select {
+		case <-ctx.Done():
+			// deadlines are enforced by the conn deadline, which keeps the
+			// session usable
+			if errors.Is(ctx.Err(), context.Canceled) {
+				t.conn.Close()
+			}
+		case <-done:
+		}
+	}()
+
+	out, err := t.exchange(cmd)
+	if err != nil {
+		return "", contextError(ctx, err)
+	}
+	return out, nil
+}
+
+func (t *rconTransport) exchange(cmd string) (string, error) {
+	id := t.nextID()
+	mirrorID := t.nextID()
+	if err := t.write(id, rconExecCommand, cmd); err != nil {
+		return "", err
+	}
+	if err := t.write(mirrorID, rconResponseValue, ""); err != nil {
+		return "", err
+	}
+
+	var (
+		buf       bytes.Buffer
+		sawMirror bool
+	)
+	for {
+		p, err := t.read()
+		if err != nil {
+			// a slow server, the partial response is discarded
+			var netErr net.Error
+			if errors.As(err, &netErr) && netErr.Timeout() {
+				return "", err
+			}
+			if buf.Len() > 0 || errors.Is(err, io.ErrUnexpectedEOF) {
+				return "", fmt.Errorf("%w after %d bytes: %v", ErrTruncatedResponse, buf.Len(), err)
+			}
+			return "", err
+		}
+		if p.typ != rconResponseValue {
+			return "", fmt.Errorf("%w: unexpected packet type %d", ErrInvalidResponse, p.typ)
+		}
+		switch {
+		case sawMirror:
+			if p.id != mirrorID || !bytes.Equal(p.body, rconTrailer) {
+				return "", fmt.Errorf("%w: invalid response trailer", ErrInvalidResponse)
+			}
+			return buf.String(), nil
+		case p.id == mirrorID:
+			sawMirror = true
+		case p.id == id:
+			buf.Write(p.body)
+		case p.id > 0 && p.id < id:
+			// late response of an earlier request which timed out
+			continue
+		default:
+			return "", fmt.Errorf("%w: unexpected packet id %d", ErrInvalidResponse, p.id)
+		}
+	}
+}
+
+// Health sends an empty command, srcds answers it with an empty response
+func (t *rconTransport) Health() error {
+	_, err := t.Send(context.Background(), "")
+	return err
+}
+
+func (t *rconTransport) Close() error {
+	return t.conn.Close()
+}
+
+func (t *rconTransport) nextID() int32 {
+	t.id++
+	if t.id < 0 {
+		t.id = 1
+	}
+	return t.id
+}
+
+type rconPacket struct {
+	id   int32
+	typ  int32
+	body []byte
+}
+
+func (t *rconTransport) write(id int32, typ int32, body string) error {
+	buf := make([]byte, 4+rconHeaderSize+len(body))
+	binary.LittleEndian.PutUint32(buf[0:4], uint32(rconHeaderSize+len(body)))
+	binary.LittleEndian.PutUint32(buf[4:8], uint32(id))
+	binary.LittleEndian.PutUint32(buf[8:12], uint32(typ))
+	copy(buf[12:], body)
+	_, err := t.conn.Write(buf)
+	return err
+}
+
+// read reads a complete packet, packets can span multiple TCP reads
+func (t *rconTransport) read() (*rconPacket, error) {
+	var size int32
+	if err := binary.Read(t.reader, binary.LittleEndian, &size); err != nil {
+		return nil, err
+	}
+	if size < rconHeaderSize || size > rconMaxPacketSize {
+		return nil, fmt.Errorf("%w: invalid packet size %d", ErrInvalidResponse, size)
+	}
+	data := make([]byte, size)
+	if _, err := io.ReadFull(t.reader, data); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+	return &rconPacket{
+		id:   int32(binary.LittleEndian.Uint32(data[0:4])),
+		typ:  int32(binary.LittleEndian.Uint32(data[4:8])),
+		body: data[8 : size-2],
+	}, nil
+}
@@ -0,0 +1,121 @@
+/*
+Copyright 2020 Alexander Trost <galexrt@googlemail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package connector
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/galexrt/srcds_exporter/parser"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// largeStatus returns a `status` output with the given number of players
+func largeStatus(players int) string {
+	var b strings.Builder
+	b.WriteString("hostname: Test Server\n")
+	b.WriteString("map     : pl_upward at: 0 x, 0 y, 0 z\n")
+	fmt.Fprintf(&b, "players : %d humans, 0 bots (%d max)\n", players, players)
+	b.WriteString("# userid name                uniqueid            connected ping loss state  adr\n")
+	for i := 0; i < players; i++ {
+		fmt.Fprintf(&b, "# %6d \"Player %03d\"        [U:1:%d]       07:36       65    0 active 10.10.%d.%d:27005\n",
+			i+2, i, 1000000+i, i/250, i%250)
+	}
+	return b.String()
+}
+
+func TestRCONLargeResponse(t *testing.T) {
+	tests := []struct {
+		name          string
+		players       int
+		maxPacketSize int
+		chunkSize     int
+	}{
+		{name: "128 players", players: 128},
+		{name: "255 players small packets", players: 255, maxPacketSize: 512},
+		{name: "100 players partial reads", players: 100, chunkSize: 7},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			s := newTestServer(t)
+			status := largeStatus(test.players)
+			require.True(t, len(status) > 4096, "response must span multiple packets")
+			s.Handle("status", status)
+			if test.maxPacketSize > 0 {
+				s.SetMaxPacketSize(test.maxPacketSize)
+			}
+			s.SetChunkSize(test.chunkSize)
+
+			transport, err := DialRCON(&ConnectionOptions{
+				Addr:           s.Addr,
+				RconPassword:   "secret",
+				ConnectTimeout: "5s",
+			})
+			require.NoError(t, err)
+			defer transport.Close()
+
+			for i := 0; i < 2; i++ {
+				out, err := transport.Send(context.Background(), "status")
+				require.NoError(t, err)
+				assert.Equal(t, status, out)
+				players, err := parser.ParsePlayers(out)
+				require.NoError(t, err)
+				assert.Len(t, players, test.players)
+			}
+		})
+	}
+}
+
+func TestRCONSlowResponse(t *testing.T) {
+	s := newTestServer(t)
+	s.Handle("status", largeStatus(100))
+	s.SetStallAfter(5000)
+	transport, err := Transports[ProtocolRCON](&ConnectionOptions{
+		Addr:           s.Addr,
+		RconPassword:   "secret",
+		ConnectTimeout: "100ms",
+	})
+	require.NoError(t, err)
+	defer transport.Close()
+
+	// the deadline passing after a part of the response is a timeout
+	_, err = transport.Send(context.Background(), "status")
+	assert.False(t, errors.Is(err, ErrTruncatedResponse), "%v", err)
+	assert.Equal(t, errorTimeout, errorType(err))
+}
+
+func TestRCONTruncatedResponse(t *testing.T) {
+	s := newTestServer(t)
+	s.Handle("status", largeStatus(100))
+	s.SetTruncateAfter(5000)
+	con := newTestConnection(t, &ConnectionOptions{
+		Addr:         s.Addr,
+		RconPassword: "secret",
+	})
+	waitForState(t, con, StateHealthy)
+
+	_, err := con.Get(context.Background(), "status")
+	assert.True(t, errors.Is(err, ErrTruncatedResponse))
+	con.stats.mu.Lock()
+	defer con.stats.mu.Unlock()
+	assert.Equal(t, float64(1), con.stats.truncated["status"])
+	assert.Equal(t, float64(1), con.stats.errors[errorKey{command: "status", typ: errorDecode}])
+}
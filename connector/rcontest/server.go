@@ -0,0 +1,424 @@
+/*
+Copyright 2020 Alexander Trost <galexrt@googlemail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package rcontest provides an in-process Source RCON server for tests.
+//
+// The server implements the Source RCON wire protocol over a local TCP
+// listener, including the empty response value "mirror" packet clients use to
+// detect the end of multi-packet responses. Responses are scriptable per
+// command, authentication failures, bans, slow replies and the packet size
+// responses are split at can be configured.
+package rcontest
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"sync"
+	"time"
+)
+
+// Packet types of the Source RCON protocol
+const (
+	TypeResponseValue = 0
+	TypeExecCommand   = 2
+	TypeAuthResponse  = 2
+	TypeAuth          = 3
+)
+
+// DefaultMaxPacketSize srcds splits responses into packets of 4096 bytes
+const DefaultMaxPacketSize = 4096
+
+// maxRequestSize srcds drops connections sending larger packets
+const maxRequestSize = 4096 + 10
+
+// HandlerFunc returns the response for a command
+type HandlerFunc func(cmd string) string
+
+// Server is an in-process Source RCON server
+type Server struct {
+	// Addr address the server is listening on
+	Addr string
+
+	listener net.Listener
+	wg       sync.WaitGroup
+
+	mu            sync.Mutex
+	password      string
+	handlers      map[string]HandlerFunc
+	delay         time.Duration
+	maxPacketSize int
+	chunkSize     int
+	truncateAfter int
+	stallAfter    int
+	maxFailures   int
+	failures      int
+	banned        bool
+	conns         map[net.Conn]struct{}
+	connections   int
+	authAttempts  int
+	commands      []string
+	closed        bool
+}
+
+// NewServer starts a new server with the given RCON password on a random
+// local port
+func NewServer(password string) (*Server, error) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+	s := &Server{
+		Addr:          l.Addr().String(),
+		listener:      l,
+		password:      password,
+		handlers:      map[string]HandlerFunc{},
+		maxPacketSize: DefaultMaxPacketSize,
+		conns:         map[net.Conn]struct{}{},
+	}
+	s.wg.Add(1)
+	go s.serve()
+	return s, nil
+}
+
+// Handle sets a static response for a command
+func (s *Server) Handle(cmd string, response string) {
+	s.HandleFunc(cmd, func(string) string {
+		return response
+	})
+}
+
+// HandleFunc sets the handler for a command
+func (s *Server) HandleFunc(cmd string, fn HandlerFunc) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.handlers[cmd] = fn
+}
+
+// SetPassword changes the RCON password, existing sessions stay authenticated
+func (s *Server) SetPassword(password string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.password = password
+}
+
+// SetDelay delays every command response by d
+func (s *Server) SetDelay(d time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.delay = d
+}
+
+// SetMaxPacketSize sets the body size responses are split at
+func (s *Server) SetMaxPacketSize(size int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.maxPacketSize = size
+}
+
+// SetChunkSize splits writes into chunks of the given size so clients have to
+// reassemble packets from multiple reads, zero writes whole packets
+func (s *Server) SetChunkSize(size int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.chunkSize = size
+}
+
+// SetTruncateAfter closes the connection after the given number of bytes of a
+// command response have been written, zero disables truncation
+func (s *Server) SetTruncateAfter(n int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.truncateAfter = n
+}
+
+// SetStallAfter stops writing after the given number of bytes of a command
+// response have been written and keeps the connection open until the client
+// closes it, zero disables stalling
+func (s *Server) SetStallAfter(n int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.stallAfter = n
+}
+
+// SetMaxFailures bans clients after the given number of failed
+// authentications like srcds' sv_rcon_maxfailures, zero disables banning.
+// Banned clients are disconnected right after connecting.
+func (s *Server) SetMaxFailures(n int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.maxFailures = n
+}
+
+// Banned returns whether clients have been banned
+func (s *Server) Banned() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.banned
+}
+
+// Connections returns the number of accepted connections
+func (s *Server) Connections() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.connections
+}
+
+// AuthAttempts returns the number of authentication requests
+func (s *Server) AuthAttempts() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.authAttempts
+}
+
+// Commands returns all commands received by authenticated clients
+func (s *Server) Commands() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string{}, s.commands...)
+}
+
+// DropConnections closes all client connections
+func (s *Server) DropConnections() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for conn := range s.conns {
+		conn.Close()
+	}
+}
+
+// Close stops the server and closes all client connections
+func (s *Server) Close() error {
+	s.mu.Lock()
+	s.closed = true
+	s.mu.Unlock()
+	err := s.listener.Close()
+	s.DropConnections()
+	s.wg.Wait()
+	return err
+}
+
+func (s *Server) serve() {
+	defer s.wg.Done()
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+		s.mu.Lock()
+		if s.closed {
+			s.mu.Unlock()
+			conn.Close()
+			return
+		}
+		s.connections++
+		if s.banned {
+			s.mu.Unlock()
+			conn.Close()
+			continue
+		}
+		s.conns[conn] = struct{}{}
+		s.mu.Unlock()
+
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			s.handle(conn)
+			s.mu.Lock()
+			delete(s.conns, conn)
+			s.mu.Unlock()
+			conn.Close()
+		}()
+	}
+}
+
+// Packet a single Source RCON packet
+type Packet struct {
+	ID   int32
+	Type int32
+	Body []byte
+}
+
+// ReadPacket reads a single packet
+func ReadPacket(r io.Reader) (*Packet, error) {
+	var size int32
+	if err := binary.Read(r, binary.LittleEndian, &size); err != nil {
+		return nil, err
+	}
+	if size < 10 || size > maxRequestSize {
+		return nil, fmt.Errorf("rcontest: invalid packet size %d", size)
+	}
+	data := make([]byte, size)
+	if _, err := io.ReadFull(r, data); err != nil {
+		return nil, err
+	}
+	return &Packet{
+		ID:   int32(binary.LittleEndian.Uint32(data[0:4])),
+		Type: int32(binary.LittleEndian.Uint32(data[4:8])),
+		// body is followed by two null bytes
+		Body: data[8 : len(data)-2],
+	}, nil
+}
+
+// Marshal encodes the packet in the wire format
+func (p *Packet) Marshal() []byte {
+	buf := &bytes.Buffer{}
+	binary.Write(buf, binary.LittleEndian, int32(len(p.Body)+10))
+	binary.Write(buf, binary.LittleEndian, p.ID)
+	binary.Write(buf, binary.LittleEndian, p.Type)
+	buf.Write(p.Body)
+	buf.Write([]byte{0x00, 0x00})
+	return buf.Bytes()
+}
+
+func (s *Server) handle(conn net.Conn) {
+	authenticated := false
+	for {
+		req, err := ReadPacket(conn)
+		if err != nil {
+			return
+		}
+		switch {
+		case req.Type == TypeAuth:
+			if authenticated = s.authenticate(conn, req); !authenticated && s.Banned() {
+				return
+			}
+		case !authenticated:
+			// srcds drops unauthenticated clients sending commands
+			return
+		case req.Type == TypeExecCommand:
+			if err := s.exec(conn, req); err != nil {
+				return
+			}
+		case req.Type == TypeResponseValue:
+			// The "mirror" packet, answered by an empty response value and a
+			// packet with an unusual body clients use as end marker.
+			if err := s.writePackets(conn,
+				&Packet{ID: req.ID, Type: TypeResponseValue},
+				&Packet{ID: req.ID, Type: TypeResponseValue, Body: []byte{0x00, 0x01, 0x00, 0x00}},
+			); err != nil {
+				return
+			}
+		default:
+			return
+		}
+	}
+}
+
+func (s *Server) authenticate(conn net.Conn, req *Packet) bool {
+	s.mu.Lock()
+	s.authAttempts++
+	ok := string(bytes.TrimRight(req.Body, "\x00")) == s.password
+	if !ok {
+		s.failures++
+		if s.maxFailures > 0 && s.failures >= s.maxFailures {
+			s.banned = true
+		}
+	}
+	s.mu.Unlock()
+
+	id := req.ID
+	if !ok {
+		id = -1
+	}
+	s.writePackets(conn,
+		&Packet{ID: req.ID, Type: TypeResponseValue},
+		&Packet{ID: id, Type: TypeAuthResponse},
+	)
+	return ok
+}
+
+func (s *Server) exec(conn net.Conn, req *Packet) error {
+	cmd := string(bytes.TrimRight(req.Body, "\x00"))
+	s.mu.Lock()
+	s.commands = append(s.commands, cmd)
+	handler, ok := s.handlers[cmd]
+	delay := s.delay
+	maxPacketSize := s.maxPacketSize
+	truncateAfter := s.truncateAfter
+	stallAfter := s.stallAfter
+	s.mu.Unlock()
+
+	var response string
+	switch {
+	case ok:
+		response = handler(cmd)
+	case cmd == "":
+		response = ""
+	default:
+		response = fmt.Sprintf("Unknown command \"%s\"\n", cmd)
+	}
+	if delay > 0 {
+		time.Sleep(delay)
+	}
+
+	body := []byte(response)
+	packets := []*Packet{}
+	for len(body) > maxPacketSize {
+		packets = append(packets, &Packet{ID: req.ID, Type: TypeResponseValue, Body: body[:maxPacketSize]})
+		body = body[maxPacketSize:]
+	}
+	packets = append(packets, &Packet{ID: req.ID, Type: TypeResponseValue, Body: body})
+	if truncateAfter > 0 || stallAfter > 0 {
+		data := []byte{}
+		for _, p := range packets {
+			data = append(data, p.Marshal()...)
+		}
+		if truncateAfter > 0 && truncateAfter < len(data) {
+			s.write(conn, data[:truncateAfter])
+			return io.ErrShortWrite
+		}
+		if stallAfter > 0 && stallAfter < len(data) {
+			s.write(conn, data[:stallAfter])
+			io.Copy(ioutil.Discard, conn)
+			return io.ErrShortWrite
+		}
+	}
+	return s.writePackets(conn, packets...)
+}
+
+func (s *Server) writePackets(conn net.Conn, packets ...*Packet) error {
+	for _, p := range packets {
+		if err := s.write(conn, p.Marshal()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// write writes data in chunks of the configured chunk size
+func (s *Server) write(conn net.Conn, data []byte) error {
+	s.mu.Lock()
+	chunkSize := s.chunkSize
+	s.mu.Unlock()
+	if chunkSize <= 0 {
+		chunkSize = len(data)
+	}
+	for len(data) > 0 {
+		n := chunkSize
+		if n > len(data) {
+			n = len(data)
+		}
+		if _, err := conn.Write(data[:n]); err != nil {
+			return err
+		}
+		data = data[n:]
+	}
+	return nil
+}
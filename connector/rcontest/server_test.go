@@ -0,0 +1,126 @@
+/*
+Copyright 2020 Alexander Trost <galexrt@googlemail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rcontest_test
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/galexrt/srcds_exporter/connector"
+	"github.com/galexrt/srcds_exporter/connector/rcontest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newServer(t *testing.T) *rcontest.Server {
+	s, err := rcontest.NewServer("secret")
+	require.NoError(t, err)
+	t.Cleanup(func() { s.Close() })
+	return s
+}
+
+func dial(s *rcontest.Server, password string, timeout string) (connector.Transport, error) {
+	return connector.DialRCON(&connector.ConnectionOptions{
+		Addr:           s.Addr,
+		RconPassword:   password,
+		ConnectTimeout: timeout,
+	})
+}
+
+func TestServerCommands(t *testing.T) {
+	s := newServer(t)
+	s.Handle("status", "hostname: Test Server\n")
+
+	transport, err := dial(s, "secret", "1s")
+	require.NoError(t, err)
+	defer transport.Close()
+
+	out, err := transport.Send(context.Background(), "status")
+	require.NoError(t, err)
+	assert.Equal(t, "hostname: Test Server\n", out)
+
+	out, err = transport.Send(context.Background(), "nope")
+	require.NoError(t, err)
+	assert.Equal(t, "Unknown command \"nope\"\n", out)
+
+	assert.NoError(t, transport.Health())
+	assert.Equal(t, []string{"status", "nope", ""}, s.Commands())
+	assert.Equal(t, 1, s.Connections())
+	assert.Equal(t, 1, s.AuthAttempts())
+}
+
+func TestServerMultiPacket(t *testing.T) {
+	s := newServer(t)
+	s.SetMaxPacketSize(100)
+	response := strings.Repeat("0123456789", 95)
+	s.Handle("status", response)
+
+	transport, err := dial(s, "secret", "1s")
+	require.NoError(t, err)
+	defer transport.Close()
+
+	out, err := transport.Send(context.Background(), "status")
+	require.NoError(t, err)
+	assert.Equal(t, response, out)
+}
+
+func TestServerAuthFailure(t *testing.T) {
+	s := newServer(t)
+	s.SetMaxFailures(2)
+
+	_, err := dial(s, "wrong", "1s")
+	assert.True(t, errors.Is(err, connector.ErrAuthFailed))
+	assert.False(t, s.Banned())
+
+	_, err = dial(s, "wrong", "1s")
+	assert.True(t, errors.Is(err, connector.ErrAuthFailed))
+	assert.True(t, s.Banned())
+
+	// banned clients can't connect even with the correct password
+	_, err = dial(s, "secret", "1s")
+	assert.Error(t, err)
+	assert.False(t, errors.Is(err, connector.ErrAuthFailed))
+	assert.Equal(t, 3, s.Connections())
+}
+
+func TestServerDelay(t *testing.T) {
+	s := newServer(t)
+	s.Handle("status", "slow")
+
+	transport, err := dial(s, "secret", "100ms")
+	require.NoError(t, err)
+	defer transport.Close()
+
+	s.SetDelay(300 * time.Millisecond)
+	_, err = transport.Send(context.Background(), "status")
+	assert.Error(t, err)
+}
+
+func TestServerDropConnections(t *testing.T) {
+	s := newServer(t)
+
+	transport, err := dial(s, "secret", "1s")
+	require.NoError(t, err)
+	defer transport.Close()
+	require.NoError(t, transport.Health())
+
+	s.DropConnections()
+	assert.Error(t, transport.Health())
+}
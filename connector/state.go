@@ -0,0 +1,96 @@
+/*
+Copyright 2020 Alexander Trost <galexrt@googlemail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package connector
+
+import (
+	"math/rand"
+	"time"
+)
+
+// State state of a Connection
+type State int
+
+const (
+	// StateConnecting the connection is being (re-)established
+	StateConnecting State = iota
+	// StateHealthy the connection is established and usable
+	StateHealthy
+	// StateBackoff connecting failed, waiting before the next attempt
+	StateBackoff
+	// StateAuthFailed the server rejected the RCON password, no further
+	// attempts are made
+	StateAuthFailed
+	// StateSecretFailed the RCON password couldn't be resolved, no attempts
+	// are made until a password is set
+	StateSecretFailed
+)
+
+// States all possible connection states
+var States = []State{
+	StateConnecting,
+	StateHealthy,
+	StateBackoff,
+	StateAuthFailed,
+	StateSecretFailed,
+}
+
+func (s State) String() string {
+	switch s {
+	case StateConnecting:
+		return "connecting"
+	case StateHealthy:
+		return "healthy"
+	case StateBackoff:
+		return "backoff"
+	case StateAuthFailed:
+		return "auth_failed"
+	case StateSecretFailed:
+		return "secret_failed"
+	}
+	return "unknown"
+}
+
+const (
+	backoffMin    = 1 * time.Second
+	backoffMax    = 2 * time.Minute
+	backoffFactor = 2
+	// backoffJitter fraction of the delay which is randomized
+	backoffJitter = 0.2
+)
+
+// backoff exponential backoff with jitter between connection attempts
+type backoff struct {
+	attempt int
+}
+
+// next returns the delay before the next attempt
+func (b *backoff) next() time.Duration {
+	delay := backoffMin
+	for i := 0; i < b.attempt && delay < backoffMax; i++ {
+		delay *= backoffFactor
+	}
+	if delay > backoffMax {
+		delay = backoffMax
+	}
+	b.attempt++
+	jitter := (rand.Float64()*2 - 1) * backoffJitter * float64(delay)
+	return delay + time.Duration(jitter)
+}
+
+func (b *backoff) reset() {
+	b.attempt = 0
+}
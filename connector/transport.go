@@ -0,0 +1,56 @@
+/*
+Copyright 2020 Alexander Trost <galexrt@googlemail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package connector
+
+import (
+	"context"
+	"time"
+)
+
+// Transport is the interface a command transport has to implement.
+type Transport interface {
+	// Send sends the command to the server and returns the response. When
+	// ctx is done Send returns ctx.Err(), the transport may be unusable then.
+	Send(ctx context.Context, cmd string) (string, error)
+	// Health checks whether the transport is still usable.
+	Health() error
+	// Close closes the transport.
+	Close() error
+}
+
+// Dialer opens a new Transport to the server. Rejected passwords must be
+// returned as an error wrapping ErrAuthFailed, transports should wrap
+// ErrTimeout, ErrConnectionRefused and ErrInvalidResponse where applicable.
+type Dialer func(opts *ConnectionOptions) (Transport, error)
+
+// Transports contains the Dialer of all available protocols.
+var Transports = map[string]Dialer{
+	ProtocolRCON:    DialRCON,
+	ProtocolGoldSrc: DialGoldSrc,
+}
+
+// contextError returns the error of ctx if it is done, connection deadlines
+// set from the deadline of ctx can expire before ctx is marked as done
+func contextError(ctx context.Context, err error) error {
+	if ctxErr := ctx.Err(); ctxErr != nil {
+		return ctxErr
+	}
+	if deadline, ok := ctx.Deadline(); ok && !time.Now().Before(deadline) {
+		return context.DeadlineExceeded
+	}
+	return err
+}
@@ -4,7 +4,6 @@ go 1.13
 
 require (
 	github.com/chromedp/chromedp v0.6.10
-	github.com/galexrt/go-rcon v0.0.0-20191004120034-77f90a2143f9
 	github.com/golang/protobuf v1.4.2 // indirect
 	github.com/patrickmn/go-cache v2.1.0+incompatible
 	github.com/prometheus/client_golang v1.6.0
@@ -12,6 +11,7 @@ require (
 	github.com/prometheus/procfs v0.1.0 // indirect
 	github.com/sirupsen/logrus v1.6.0
 	github.com/stretchr/testify v1.6.1
+	golang.org/x/sync v0.0.0-20201020160332-67f06af15bc9
 	golang.org/x/sys v0.0.0-20210415045647-66c3f260301c // indirect
 	gopkg.in/yaml.v2 v2.3.0
 	gopkg.in/yaml.v3 v3.0.0-20200605160147-a5ece683394c // indirect
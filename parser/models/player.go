@@ -16,14 +16,21 @@ limitations under the License.
 
 package models
 
+import "time"
+
 // Player contains player information like username, steamID, etc.
 type Player struct {
 	Username string
 	UserID   int
 	SteamID  string
 	State    string
-	Ping     int
-	Loss     int
+	// Connected time since the player connected, the status lists it with
+	// second precision
+	Connected time.Duration
+	Ping      int
+	Loss      int
+	// Rate is 0 if the dialect doesn't list it
+	Rate     int
 	IP       string
 	ConnPort int
 }
@@ -0,0 +1,35 @@
+/*
+Copyright 2020 Alexander Trost <galexrt@googlemail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package models
+
+import "time"
+
+// Stats contains the performance stats of the server
+type Stats struct {
+	// CPU usage in percent
+	CPU float64
+	// NetIn and NetOut traffic in kB/s
+	NetIn  float64
+	NetOut float64
+	Uptime time.Duration
+	// MapChanges is -1 if the dialect doesn't list it (older Source games)
+	MapChanges int
+	FPS        float64
+	Players    int
+	// Connects is -1 if the dialect doesn't list it (CS:GO)
+	Connects int
+}
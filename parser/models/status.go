@@ -18,9 +18,39 @@ package models
 
 // Status Contains the server status
 type Status struct {
-	Hostname    string
-	Version     string
-	Map         string
+	Hostname string
+	Version  string
+	// Secure whether the server is VAC secured
+	Secure bool
+	// Game name of the game, only known from A2S_INFO
+	Game string
+	// Address address the server listens on (`udp/ip` line), PublicAddress
+	// the public IP if it is listed
+	Address       string
+	PublicAddress string
+	// SteamID of the server, e.g., `[G:1:1234567]`, and its 64 bit form
+	SteamID   string
+	SteamID64 string
+	Account   string
+	Map       string
+	Tags      []string
+	// EdictsUsed and EdictsMax are -1 if the status has no `edicts` line
+	EdictsUsed int
+	EdictsMax  int
+	// Hibernating whether the server is hibernating (no players connected)
+	Hibernating bool
+	// SourceTV is nil if the status has no `sourcetv` line
+	SourceTV    *SourceTV
 	PlayerCount PlayerCount
-	Players     map[int]Player
+	// Players by SteamID, by user ID if the dialect lists no SteamIDs (CS2)
+	Players map[string]*Player
+}
+
+// SourceTV contains the SourceTV relay of the server
+type SourceTV struct {
+	// Address is empty if only the port is listed
+	Address string
+	Port    int
+	// Delay broadcast delay in seconds
+	Delay float64
 }
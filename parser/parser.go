@@ -18,21 +18,170 @@ package parser
 
 import (
 	"errors"
+	"net"
 	"regexp"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/galexrt/srcds_exporter/parser/models"
 )
 
 var (
-	hostnameRegex    = regexp.MustCompile(`(?m)^hostname\s*: (.*)$`)
-	versionRegex     = regexp.MustCompile(`(?m)^version\s*: (.*)$`)
-	mapRegex         = regexp.MustCompile(`(?m)^map\s*: ([a-zA-Z_0-9-]+) .*$`)
-	playerCountRegex = regexp.MustCompile(`(?m)^players\s*:\s*((?P<current1>[0-9]+)\s*\((?P<max1>[0-9]+)\s*max\)|(?P<humans>[0-9]+) humans,\s+(?P<bots>[0-9]+) bots\s+\((?P<max2>[0-9]+)(/[0-9]+)?\s+max\)).*$`)
-	playerRegex      = regexp.MustCompile(`(?m)^#\s+([0-9]+)\s+"([^"]*)"\s+(\S+)\s+[0-9:]+\s+([0-9]+)\s+([0-9]+)\s+([a-z]+)(\s+(([0-9]{1,3}.){3}[0-9]{1,3}):([0-9]+))?$`)
+	hostnameRegex = regexp.MustCompile(`(?m)^hostname[ \t]*:[ \t]*(.*?)[ \t]*$`)
+	versionRegex  = regexp.MustCompile(`(?m)^version[ \t]*:[ \t]*(.*?)[ \t]*$`)
+	mapRegex      = regexp.MustCompile(`(?m)^map\s*:\s*([a-zA-Z_0-9/-]+)(?:\s.*)?$`)
+	// cs2MapRegex CS2 lists the map as the main lump of the loaded spawngroup
+	cs2MapRegex      = regexp.MustCompile(`(?m)^loaded spawngroup\(\s*[0-9]+\)\s*:\s*SV:\s*\[[0-9]+:\s*([a-zA-Z_0-9/-]+)\s*\|\s*main lump`)
+	playerCountRegex = regexp.MustCompile(`(?m)^players\s*:\s*((?P<current1>[0-9]+)\s*\((?P<max1>[0-9]+)\s*max\)|(?P<current2>[0-9]+) active \((?P<max3>[0-9]+) max\)|(?P<humans>[0-9]+) humans,\s+(?P<bots>[0-9]+) bots\s+\((?P<max2>[0-9]+)(/[0-9]+)?\s+max\)).*$`)
+	playerRegex      = regexp.MustCompile(`(?m)^#\s+([0-9]+)\s+"([^"]*)"\s+(\S+)\s+([0-9:]+)\s+([0-9]+)\s+([0-9]+)\s+([a-z]+)(\s+(([0-9]{1,3}.){3}[0-9]{1,3}):([0-9]+))?$`)
+	// hldsPlayerRegex GoldSrc (HLDS) player line, the columns are slot, name,
+	// userid, uniqueid, frags, time (connected), ping, loss and address
+	hldsPlayerRegex = regexp.MustCompile(`(?m)^#\s*[0-9]+\s+"([^"]*)"\s+([0-9]+)\s+(\S+)\s+-?[0-9]+\s+([0-9:]+)\s+([0-9]+)\s+([0-9]+)(\s+(([0-9]{1,3}\.){3}[0-9]{1,3}):([0-9]+))?\s*$`)
+
+	// csgoPlayerRegex CS:GO player line, the columns are userid, slot (only
+	// listed for clients), name, uniqueid, connected, ping, loss, state, rate
+	// and address
+	csgoPlayerRegex = regexp.MustCompile(`(?m)^#\s*([0-9]+)(?:\s+[0-9]+)?\s+"([^"]*)"\s+(\S+)\s+([0-9:]+)\s+([0-9]+)\s+([0-9]+)\s+([a-z]+)\s+([0-9]+)(?:\s+(([0-9]{1,3}\.){3}[0-9]{1,3}):([0-9]+))?\s*$`)
+	// cs2PlayerRegex CS2 player line, the columns are id, time, ping, loss,
+	// state, rate, address and the quoted name, CS2 lists no SteamIDs
+	cs2PlayerRegex = regexp.MustCompile(`(?m)^\s*([0-9]+)\s+([0-9:]+|BOT)\s+([0-9]+)\s+([0-9]+)\s+([a-z]+)\s+([0-9]+)\s+(?:(([0-9]{1,3}\.){3}[0-9]{1,3}):([0-9]+)\s+|\S+\s+)?'(.*)'\s*$`)
+
+	// csVersionRegex version of CS:GO and CS2, the minor version tells them
+	// apart
+	csVersionRegex   = regexp.MustCompile(`(?m)^version\s*:\s*1\.([0-9]+)\.[0-9]+\.[0-9]+/[0-9]+\s`)
+	csgoHeaderRegex  = regexp.MustCompile(`(?m)^#\s*userid\s+name\s+uniqueid\s+connected\s+ping\s+loss\s+state\s+rate\s+adr`)
+	cs2HeaderRegex   = regexp.MustCompile(`(?m)^-+players-+\s*$`)
+	csgoSteamIDRegex = regexp.MustCompile(`(?m)^version\s*:.*\s(\[G:[0-9]+:[0-9]+\])`)
+
+	addressRegex = regexp.MustCompile(`(?m)^(?:udp|tcp)/ip[ \t]*:[ \t]*(\S+)(?:[ \t]+(?:\(public(?: ip:)? ([^)]+)\)|\[[ \t]*public ([^\]\s]+)[ \t]*\]))?`)
+	steamIDRegex = regexp.MustCompile(`(?m)^steamid[ \t]*:[ \t]*(\S+)(?:\s+\(([0-9]+)\))?`)
+	secureRegex  = regexp.MustCompile(`(?m)^version[ \t]*:.*\b(secure|insecure)\b`)
+	accountRegex = regexp.MustCompile(`(?m)^account[ \t]*:[ \t]*(.*?)[ \t]*$`)
+	// tagsRegex the tags line is empty if the server has no tags
+	tagsRegex        = regexp.MustCompile(`(?m)^tags[ \t]*:[ \t]*(.*?)[ \t]*$`)
+	edictsRegex      = regexp.MustCompile(`(?m)^edicts\s*:\s*([0-9]+) used of ([0-9]+) max`)
+	hibernatingRegex = regexp.MustCompile(`(?m)^players\s*:.*\((not )?hibernating\)`)
+	sourceTVRegex    = regexp.MustCompile(`(?m)^(?:sourcetv|gotv(?:\[[0-9]+\])?)\s*:\s*(?:port ([0-9]+)|(\S+):([0-9]+)),\s*delay ([0-9.]+)s`)
+)
+
+// Dialect layout of the `status` output of a game
+type Dialect string
+
+const (
+	// DialectSource status of most Source games (e.g., TF2 and GMod) and of
+	// GoldSrc (HLDS) games
+	DialectSource Dialect = "source"
+	// DialectCSGO status of CS:GO, the players are listed with their rate
+	DialectCSGO Dialect = "csgo"
+	// DialectCS2 status of CS2, the players are listed without SteamIDs
+	DialectCS2 Dialect = "cs2"
 )
 
+const (
+	// csgoMinMinorVersion older versions of the form 1.x.x.x are used by other
+	// games, e.g., Day of Infamy
+	csgoMinMinorVersion = 30
+	// csgoMaxMinorVersion CS:GO ended with version 1.38, CS2 started with 1.39
+	csgoMaxMinorVersion = 38
+)
+
+// DetectDialect detects the dialect of SRCDS and HLDS `status` command from
+// its version line, falls back to the header of the player list (e.g., L4D2
+// lists its players like CS:GO)
+func DetectDialect(input string) Dialect {
+	if m := csVersionRegex.FindStringSubmatch(input); m != nil {
+		minor, _ := strconv.Atoi(m[1])
+		if minor > csgoMaxMinorVersion {
+			return DialectCS2
+		}
+		if minor >= csgoMinMinorVersion {
+			return DialectCSGO
+		}
+	}
+	if cs2HeaderRegex.MatchString(input) {
+		return DialectCS2
+	}
+	if csgoHeaderRegex.MatchString(input) {
+		return DialectCSGO
+	}
+	return DialectSource
+}
+
+// ParseStatus parse SRCDS and HLDS `status` command to retrieve the complete
+// server status, fails if the player count can't be found
+func ParseStatus(input string) (*models.Status, error) {
+	input = strings.Replace(input, "\000", "", -1)
+	playerCount, err := ParsePlayerCount(input)
+	if err != nil {
+		return nil, err
+	}
+	dialect := DetectDialect(input)
+	players, err := ParsePlayersDialect(input, dialect)
+	if err != nil {
+		// the status of an empty server has no player lines
+		players = map[string]*models.Player{}
+	}
+	status := &models.Status{
+		Hostname:    ParseHostname(input),
+		Version:     ParseVersion(input),
+		Map:         ParseMap(input),
+		EdictsUsed:  -1,
+		EdictsMax:   -1,
+		PlayerCount: *playerCount,
+		Players:     players,
+	}
+	if m := addressRegex.FindStringSubmatch(input); m != nil {
+		status.Address = m[1]
+		status.PublicAddress = m[2]
+		// L4D2 lists the public address in brackets, `n/a` if it's unknown
+		if m[3] != "" && m[3] != "n/a" {
+			status.PublicAddress = m[3]
+		}
+		// CS2 lists the public address with the port
+		if host, _, err := net.SplitHostPort(m[2]); err == nil {
+			status.PublicAddress = host
+		}
+	}
+	if m := steamIDRegex.FindStringSubmatch(input); m != nil {
+		status.SteamID = m[1]
+		status.SteamID64 = m[2]
+	} else if m := csgoSteamIDRegex.FindStringSubmatch(input); m != nil {
+		// CS:GO lists the SteamID of the server in the version line
+		status.SteamID = m[1]
+	}
+	if m := secureRegex.FindStringSubmatch(input); m != nil {
+		status.Secure = m[1] == "secure"
+	}
+	if m := accountRegex.FindStringSubmatch(input); m != nil {
+		status.Account = m[1]
+	}
+	if m := tagsRegex.FindStringSubmatch(input); m != nil {
+		for _, tag := range strings.Split(m[1], ",") {
+			if tag = strings.TrimSpace(tag); tag != "" {
+				status.Tags = append(status.Tags, tag)
+			}
+		}
+	}
+	if m := edictsRegex.FindStringSubmatch(input); m != nil {
+		status.EdictsUsed, _ = strconv.Atoi(m[1])
+		status.EdictsMax, _ = strconv.Atoi(m[2])
+	}
+	if m := hibernatingRegex.FindStringSubmatch(input); m != nil {
+		status.Hibernating = m[1] == ""
+	}
+	if m := sourceTVRegex.FindStringSubmatch(input); m != nil {
+		status.SourceTV = &models.SourceTV{Address: m[2]}
+		if m[1] != "" {
+			status.SourceTV.Port, _ = strconv.Atoi(m[1])
+		} else {
+			status.SourceTV.Port, _ = strconv.Atoi(m[3])
+		}
+		status.SourceTV.Delay, _ = strconv.ParseFloat(m[4], 64)
+	}
+	return status, nil
+}
+
 // ParseHostname parse SRCDS `status` command to retrieve server hostname
 func ParseHostname(input string) string {
 	result := hostnameRegex.FindStringSubmatch(input)
@@ -51,12 +200,27 @@ func ParseVersion(input string) string {
 	return ""
 }
 
+// VersionToken returns the version of a `status` version line without the
+// build number and flags following it, e.g., "8835751/24" of
+// "8835751/24 8835751 secure"
+func VersionToken(version string) string {
+	fields := strings.Fields(version)
+	if len(fields) == 0 {
+		return ""
+	}
+	return fields[0]
+}
+
 // ParseMap parse SRCDS `status` command to retrieve server map
 func ParseMap(input string) string {
 	result := mapRegex.FindStringSubmatch(input)
 	if len(result) > 1 {
 		return result[1]
 	}
+	result = cs2MapRegex.FindStringSubmatch(input)
+	if len(result) > 1 {
+		return result[1]
+	}
 	return ""
 }
 
@@ -75,6 +239,8 @@ func ParsePlayerCount(input string) (*models.PlayerCount, error) {
 		var currentRaw string
 		if result["current1"] != "" {
 			currentRaw = result["current1"]
+		} else if result["current2"] != "" {
+			currentRaw = result["current2"]
 		} else {
 			currentRaw = "0"
 		}
@@ -84,6 +250,8 @@ func ParsePlayerCount(input string) (*models.PlayerCount, error) {
 			currentMax = result["max1"]
 		} else if result["max2"] != "" {
 			currentMax = result["max2"]
+		} else if result["max3"] != "" {
+			currentMax = result["max3"]
 		} else {
 			currentMax = "0"
 		}
@@ -117,29 +285,143 @@ func ParsePlayerCount(input string) (*models.PlayerCount, error) {
 	return nil, errors.New("no player count found in input")
 }
 
-// ParsePlayers parse SRCDS `status` command to retrieve players on server
+// ParsePlayers parse SRCDS and HLDS `status` command to retrieve players on
+// server, bots and HLTV are skipped. The dialect is detected from the input.
 func ParsePlayers(input string) (map[string]*models.Player, error) {
+	return ParsePlayersDialect(input, DetectDialect(input))
+}
+
+// ParsePlayersDialect parse `status` command of the given dialect to retrieve
+// players on server, bots and HLTV are skipped
+func ParsePlayersDialect(input string, dialect Dialect) (map[string]*models.Player, error) {
 	input = strings.Replace(input, "\000", "", -1)
+	switch dialect {
+	case DialectCSGO:
+		return parseCSGOPlayers(input)
+	case DialectCS2:
+		return parseCS2Players(input)
+	}
 	matches := playerRegex.FindAllStringSubmatch(input, -1)
-	if len(matches) == 0 {
+	hldsMatches := hldsPlayerRegex.FindAllStringSubmatch(input, -1)
+	if len(matches) == 0 && len(hldsMatches) == 0 {
 		return nil, errors.New("no matches found in input")
 	}
 	players := make(map[string]*models.Player)
-	for _, m := range matches {
-		userID, _ := strconv.Atoi(m[1])
-		ping, _ := strconv.Atoi(m[4])
-		loss, _ := strconv.Atoi(m[5])
+	for _, m := range hldsMatches {
+		if m[3] == "BOT" || m[3] == "HLTV" {
+			continue
+		}
+		userID, _ := strconv.Atoi(m[2])
+		ping, _ := strconv.Atoi(m[5])
+		loss, _ := strconv.Atoi(m[6])
 		connPort, _ := strconv.Atoi(m[10])
 		players[m[3]] = &models.Player{
-			Username: m[2],
+			Username: m[1],
 			UserID:   userID,
 			SteamID:  m[3],
-			State:    m[6],
-			Ping:     ping,
-			Loss:     loss,
-			IP:       m[8],
-			ConnPort: connPort,
+			// HLDS only lists active players
+			State:     "active",
+			Connected: parseConnected(m[4]),
+			Ping:      ping,
+			Loss:      loss,
+			IP:        m[8],
+			ConnPort:  connPort,
+		}
+	}
+	for _, m := range matches {
+		userID, _ := strconv.Atoi(m[1])
+		ping, _ := strconv.Atoi(m[5])
+		loss, _ := strconv.Atoi(m[6])
+		connPort, _ := strconv.Atoi(m[11])
+		players[m[3]] = &models.Player{
+			Username:  m[2],
+			UserID:    userID,
+			SteamID:   m[3],
+			State:     m[7],
+			Connected: parseConnected(m[4]),
+			Ping:      ping,
+			Loss:      loss,
+			IP:        m[9],
+			ConnPort:  connPort,
+		}
+	}
+	return players, nil
+}
+
+// parseCSGOPlayers parse the players of CS:GO `status` command
+func parseCSGOPlayers(input string) (map[string]*models.Player, error) {
+	matches := csgoPlayerRegex.FindAllStringSubmatch(input, -1)
+	if len(matches) == 0 {
+		return nil, errors.New("no matches found in input")
+	}
+	players := make(map[string]*models.Player)
+	for _, m := range matches {
+		if m[3] == "BOT" {
+			continue
+		}
+		userID, _ := strconv.Atoi(m[1])
+		ping, _ := strconv.Atoi(m[5])
+		loss, _ := strconv.Atoi(m[6])
+		rate, _ := strconv.Atoi(m[8])
+		connPort, _ := strconv.Atoi(m[11])
+		players[m[3]] = &models.Player{
+			Username:  m[2],
+			UserID:    userID,
+			SteamID:   m[3],
+			State:     m[7],
+			Connected: parseConnected(m[4]),
+			Ping:      ping,
+			Loss:      loss,
+			Rate:      rate,
+			IP:        m[9],
+			ConnPort:  connPort,
+		}
+	}
+	return players, nil
+}
+
+// parseCS2Players parse the players of CS2 `status` command, they are keyed
+// by their user ID as CS2 lists no SteamIDs
+func parseCS2Players(input string) (map[string]*models.Player, error) {
+	matches := cs2PlayerRegex.FindAllStringSubmatch(input, -1)
+	if len(matches) == 0 {
+		return nil, errors.New("no matches found in input")
+	}
+	players := make(map[string]*models.Player)
+	for _, m := range matches {
+		if m[2] == "BOT" {
+			continue
+		}
+		userID, _ := strconv.Atoi(m[1])
+		ping, _ := strconv.Atoi(m[3])
+		loss, _ := strconv.Atoi(m[4])
+		rate, _ := strconv.Atoi(m[6])
+		connPort, _ := strconv.Atoi(m[9])
+		players[m[1]] = &models.Player{
+			Username:  m[10],
+			UserID:    userID,
+			State:     m[5],
+			Connected: parseConnected(m[2]),
+			Ping:      ping,
+			Loss:      loss,
+			Rate:      rate,
+			IP:        m[7],
+			ConnPort:  connPort,
 		}
 	}
 	return players, nil
 }
+
+// parseConnected parse the connected column of a player, e.g., `07:36` or
+// `1:02:03`
+func parseConnected(connected string) time.Duration {
+	var d time.Duration
+	for _, part := range strings.Split(connected, ":") {
+		value, err := strconv.Atoi(part)
+		if err != nil {
+			return 0
+		}
+		d = d*60 + time.Duration(value)
+	}
+	return d * time.Second
+}
@@ -17,12 +17,21 @@ limitations under the License.
 package parser
 
 import (
+	"encoding/json"
+	"flag"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/galexrt/srcds_exporter/parser/models"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
+var update = flag.Bool("update", false, "update the golden files of the status tests")
+
 var parseHostnameTests = []struct {
 	request  string
 	expected string
@@ -35,6 +44,10 @@ var parseHostnameTests = []struct {
 		`hostname: [TEST] ÜÄÖÜ server`,
 		"[TEST] ÜÄÖÜ server",
 	},
+	{
+		`hostname:  Counter-Strike 1.6 Server`,
+		"Counter-Strike 1.6 Server",
+	},
 	{
 		`nope: nope`,
 		"",
@@ -69,6 +82,23 @@ func TestParseVersion(t *testing.T) {
 	}
 }
 
+var versionTokenTests = []struct {
+	version  string
+	expected string
+}{
+	{"8835751/24 8835751 secure", "8835751/24"},
+	{"1.38.3.8/13838 1252/8117 secure  [G:1:3442112]", "1.38.3.8/13838"},
+	{"2.2.2.6 8491 secure  (unknown)", "2.2.2.6"},
+	{"8835751", "8835751"},
+	{"", ""},
+}
+
+func TestVersionToken(t *testing.T) {
+	for _, tt := range versionTokenTests {
+		assert.Equal(t, tt.expected, VersionToken(tt.version))
+	}
+}
+
 var parseMapTests = []struct {
 	request  string
 	expected string
@@ -77,6 +107,10 @@ var parseMapTests = []struct {
 		`map     : rp_retribution_v2 at: 0 x, 0 y, 0 z`,
 		"rp_retribution_v2",
 	},
+	{
+		`map     :  de_dust2 at: 0 x, 0 y, 0 z`,
+		"de_dust2",
+	},
 	{
 		`nope: nope`,
 		"",
@@ -120,6 +154,16 @@ var parsePlayerCountTests = []struct {
 		},
 		false,
 	},
+	{
+		`players :  3 active (32 max)`,
+		&models.PlayerCount{
+			Current: 3,
+			Max:     32,
+			Humans:  -1,
+			Bots:    -1,
+		},
+		false,
+	},
 	{
 		`players : 2 humans, 2 bots (4 max)`,
 		&models.PlayerCount{
@@ -153,14 +197,15 @@ var parsePlayersTests = []struct {
 		`#    218 "TestUser1"      STEAM_0:0:1015738 07:36       65    0 active 10.10.220.12:27005`,
 		map[string]*models.Player{
 			"STEAM_0:0:1015738": &models.Player{
-				Username: "TestUser1",
-				SteamID:  "STEAM_0:0:1015738",
-				UserID:   218,
-				Ping:     65,
-				Loss:     0,
-				State:    "active",
-				IP:       "10.10.220.12",
-				ConnPort: 27005,
+				Username:  "TestUser1",
+				SteamID:   "STEAM_0:0:1015738",
+				UserID:    218,
+				Ping:      65,
+				Loss:      0,
+				State:     "active",
+				Connected: 7*time.Minute + 36*time.Second,
+				IP:        "10.10.220.12",
+				ConnPort:  27005,
 			},
 		},
 		false,
@@ -174,14 +219,15 @@ var parsePlayersTests = []struct {
 		`#    5 "TestUser2"      [U:1:1234567]      00:11       74    0 active 192.168.1.5:27005`,
 		map[string]*models.Player{
 			"[U:1:1234567]": &models.Player{
-				Username: "TestUser2",
-				SteamID:  "[U:1:1234567]",
-				UserID:   5,
-				Ping:     74,
-				Loss:     0,
-				State:    "active",
-				IP:       "192.168.1.5",
-				ConnPort: 27005,
+				Username:  "TestUser2",
+				SteamID:   "[U:1:1234567]",
+				UserID:    5,
+				Ping:      74,
+				Loss:      0,
+				State:     "active",
+				Connected: 11 * time.Second,
+				IP:        "192.168.1.5",
+				ConnPort:  27005,
 			},
 		},
 		false,
@@ -190,14 +236,34 @@ var parsePlayersTests = []struct {
 		`#    5 "TestUser2"      [U:1:1234567]      00:11       74    0 active`,
 		map[string]*models.Player{
 			"[U:1:1234567]": &models.Player{
-				Username: "TestUser2",
-				SteamID:  "[U:1:1234567]",
-				UserID:   5,
-				Ping:     74,
-				Loss:     0,
-				State:    "active",
-				IP:       "",
-				ConnPort: 0,
+				Username:  "TestUser2",
+				SteamID:   "[U:1:1234567]",
+				UserID:    5,
+				Ping:      74,
+				Loss:      0,
+				State:     "active",
+				Connected: 11 * time.Second,
+				IP:        "",
+				ConnPort:  0,
+			},
+		},
+		false,
+	},
+	{
+		`# 1 "Player1"   1 STEAM_0:1:12345   5 10:24   45    0 192.168.1.10:27005
+# 2 "[POD]Bot"  2 BOT               0 30:43    0    0
+# 3 "SourceTV"  3 HLTV              0 30:43    0    0 192.168.1.2:27020`,
+		map[string]*models.Player{
+			"STEAM_0:1:12345": &models.Player{
+				Username:  "Player1",
+				SteamID:   "STEAM_0:1:12345",
+				UserID:    1,
+				Ping:      45,
+				Loss:      0,
+				State:     "active",
+				Connected: 10*time.Minute + 24*time.Second,
+				IP:        "192.168.1.10",
+				ConnPort:  27005,
 			},
 		},
 		false,
@@ -215,3 +281,344 @@ func TestParsePlayers(t *testing.T) {
 		assert.Equal(t, tt.expected, actual)
 	}
 }
+
+var parseStatusTests = []struct {
+	request  string
+	expected *models.Status
+	errOkay  bool
+}{
+	{
+		`hostname: Test Server
+version : 6300758/24 6300758 secure
+udp/ip  : 10.0.0.1:27015  (public ip: 1.2.3.4)
+steamid : [G:1:1234567] (85568392921274567)
+account : not logged in  (No account specified)
+map     : pl_upward at: 0 x, 0 y, 0 z
+tags    : payload,increased_maxplayers
+sourcetv:  1.2.3.4:27020, delay 90.0s  (local: 0.0.0.0:27020)
+players : 1 humans, 1 bots (24 max)
+edicts  : 926 used of 2048 max
+# userid name                uniqueid            connected ping loss state  adr
+#      2 "SourceTV"          BOT                                     active
+#      3 "TestUser1"         [U:1:1015738]       07:36       65    0 active 10.10.220.12:27005
+`,
+		&models.Status{
+			Hostname:      "Test Server",
+			Version:       "6300758/24 6300758 secure",
+			Secure:        true,
+			Address:       "10.0.0.1:27015",
+			PublicAddress: "1.2.3.4",
+			SteamID:       "[G:1:1234567]",
+			SteamID64:     "85568392921274567",
+			Account:       "not logged in  (No account specified)",
+			Map:           "pl_upward",
+			Tags:          []string{"payload", "increased_maxplayers"},
+			EdictsUsed:    926,
+			EdictsMax:     2048,
+			SourceTV: &models.SourceTV{
+				Address: "1.2.3.4",
+				Port:    27020,
+				Delay:   90,
+			},
+			PlayerCount: models.PlayerCount{
+				Current: 2,
+				Max:     24,
+				Humans:  1,
+				Bots:    1,
+			},
+			Players: map[string]*models.Player{
+				"[U:1:1015738]": &models.Player{
+					Username:  "TestUser1",
+					SteamID:   "[U:1:1015738]",
+					UserID:    3,
+					Ping:      65,
+					Loss:      0,
+					State:     "active",
+					Connected: 7*time.Minute + 36*time.Second,
+					IP:        "10.10.220.12",
+					ConnPort:  27005,
+				},
+			},
+		},
+		false,
+	},
+	{
+		`hostname: Empty Server
+map     : de_dust2 at: 0 x, 0 y, 0 z
+sourcetv:  port 27020, delay 30.0s
+players : 0 humans, 0 bots (20/0 max) (hibernating)
+`,
+		&models.Status{
+			Hostname:    "Empty Server",
+			Map:         "de_dust2",
+			EdictsUsed:  -1,
+			EdictsMax:   -1,
+			Hibernating: true,
+			SourceTV: &models.SourceTV{
+				Port:  27020,
+				Delay: 30,
+			},
+			PlayerCount: models.PlayerCount{
+				Current: 0,
+				Max:     20,
+				Humans:  0,
+				Bots:    0,
+			},
+			Players: map[string]*models.Player{},
+		},
+		false,
+	},
+	{
+		`hostname:  Counter-Strike 1.6 Server
+version :  48/1.1.2.7/Stdio 8684 secure  (10)
+tcp/ip  :  10.0.0.1:27015
+map     :  de_dust2 at: 0 x, 0 y, 0 z
+players :  1 active (32 max)
+
+#      name userid uniqueid frag time ping loss adr
+# 1 "Player1"   1 STEAM_0:1:12345   5 10:24   45    0 192.168.1.10:27005
+1 users
+`,
+		&models.Status{
+			Hostname:   "Counter-Strike 1.6 Server",
+			Version:    "48/1.1.2.7/Stdio 8684 secure  (10)",
+			Secure:     true,
+			Address:    "10.0.0.1:27015",
+			Map:        "de_dust2",
+			EdictsUsed: -1,
+			EdictsMax:  -1,
+			PlayerCount: models.PlayerCount{
+				Current: 1,
+				Max:     32,
+				Humans:  -1,
+				Bots:    -1,
+			},
+			Players: map[string]*models.Player{
+				"STEAM_0:1:12345": &models.Player{
+					Username:  "Player1",
+					SteamID:   "STEAM_0:1:12345",
+					UserID:    1,
+					Ping:      45,
+					Loss:      0,
+					State:     "active",
+					Connected: 10*time.Minute + 24*time.Second,
+					IP:        "192.168.1.10",
+					ConnPort:  27005,
+				},
+			},
+		},
+		false,
+	},
+	{
+		`NOPE`,
+		nil,
+		true,
+	},
+}
+
+func TestParseStatus(t *testing.T) {
+	for _, tt := range parseStatusTests {
+		actual, err := ParseStatus(tt.request)
+		if tt.errOkay {
+			assert.Error(t, err)
+		} else {
+			assert.NoError(t, err)
+		}
+		assert.Equal(t, tt.expected, actual)
+	}
+}
+
+var detectDialectTests = []struct {
+	request  string
+	expected Dialect
+}{
+	{
+		`version : 6300758/24 6300758 secure`,
+		DialectSource,
+	},
+	{
+		`version : 1.38.3.8/13838 1252/8117 secure  [G:1:3442112] `,
+		DialectCSGO,
+	},
+	{
+		`version  : 1.40.0.8/14008 10237 secure  public`,
+		DialectCS2,
+	},
+	{
+		`# userid name uniqueid connected ping loss state rate adr`,
+		DialectCSGO,
+	},
+	{
+		`---------players--------`,
+		DialectCS2,
+	},
+	{
+		`nope: nope`,
+		DialectSource,
+	},
+}
+
+func TestDetectDialect(t *testing.T) {
+	for _, tt := range detectDialectTests {
+		assert.Equal(t, tt.expected, DetectDialect(tt.request))
+	}
+}
+
+// parseStatusDialectTests the CS:GO and CS2 inputs are synthetic, they were
+// written after the layout of the dialects and not captured from a server.
+// Replace them with real captures before listing the games as supported.
+var parseStatusDialectTests = []struct {
+	file     string
+	expected *models.Status
+}{
+	{
+		"csgo_synthetic.txt",
+		&models.Status{
+			Hostname:      "Example CS:GO Server",
+			Version:       "1.38.3.8/13838 1252/8117 secure  [G:1:3442112]",
+			Secure:        true,
+			Address:       "0.0.0.0:27015",
+			PublicAddress: "203.0.113.10",
+			SteamID:       "[G:1:3442112]",
+			Map:           "de_dust2",
+			EdictsUsed:    -1,
+			EdictsMax:     -1,
+			SourceTV: &models.SourceTV{
+				Port:  27020,
+				Delay: 30,
+			},
+			PlayerCount: models.PlayerCount{
+				Current: 3,
+				Max:     20,
+				Humans:  2,
+				Bots:    1,
+			},
+			Players: map[string]*models.Player{
+				"STEAM_1:0:12345678": &models.Player{
+					Username:  "Player One",
+					UserID:    3,
+					SteamID:   "STEAM_1:0:12345678",
+					State:     "active",
+					Connected: 10*time.Minute + 3*time.Second,
+					Ping:      34,
+					Loss:      0,
+					Rate:      786432,
+					IP:        "198.51.100.23",
+					ConnPort:  27005,
+				},
+				"STEAM_1:1:87654321": &models.Player{
+					Username:  "Player Two",
+					UserID:    4,
+					SteamID:   "STEAM_1:1:87654321",
+					State:     "spawning",
+					Connected: 2*time.Minute + 41*time.Second,
+					Ping:      71,
+					Loss:      2,
+					Rate:      196608,
+					IP:        "198.51.100.42",
+					ConnPort:  27006,
+				},
+			},
+		},
+	},
+	{
+		"cs2_synthetic.txt",
+		&models.Status{
+			Hostname:      "Example CS2 Server",
+			Version:       "1.40.0.8/14008 10237 secure  public",
+			Secure:        true,
+			Address:       "0.0.0.0:27015",
+			PublicAddress: "203.0.113.20",
+			SteamID:       "[A:1:3906011137:26084]",
+			SteamID64:     "90178917493012481",
+			Map:           "de_mirage",
+			EdictsUsed:    -1,
+			EdictsMax:     -1,
+			PlayerCount: models.PlayerCount{
+				Current: 3,
+				Max:     0,
+				Humans:  2,
+				Bots:    1,
+			},
+			Players: map[string]*models.Player{
+				"2": &models.Player{
+					Username:  "Player One",
+					UserID:    2,
+					State:     "active",
+					Connected: 15*time.Minute + 42*time.Second,
+					Ping:      19,
+					Loss:      0,
+					Rate:      786432,
+					IP:        "198.51.100.23",
+					ConnPort:  49891,
+				},
+				"3": &models.Player{
+					Username:  "Player 'Two'",
+					UserID:    3,
+					State:     "active",
+					Connected: time.Minute + 7*time.Second,
+					Ping:      52,
+					Loss:      1,
+					Rate:      196608,
+					IP:        "198.51.100.42",
+					ConnPort:  27005,
+				},
+			},
+		},
+	},
+}
+
+func TestParseStatusDialects(t *testing.T) {
+	for _, tt := range parseStatusDialectTests {
+		input, err := ioutil.ReadFile(filepath.Join("testdata", tt.file))
+		require.NoError(t, err)
+		actual, err := ParseStatus(string(input))
+		assert.NoError(t, err, tt.file)
+		assert.Equal(t, tt.expected, actual, tt.file)
+	}
+}
+
+// TestParseStatusGolden parses the `status` outputs in testdata and diffs
+// the parsed models.Status with the golden files, run the tests with
+// `-update` to update them
+func TestParseStatusGolden(t *testing.T) {
+	files, err := filepath.Glob(filepath.Join("testdata", "*.txt"))
+	require.NoError(t, err)
+	require.NotEmpty(t, files)
+	for _, file := range files {
+		file := file
+		t.Run(filepath.Base(file), func(t *testing.T) {
+			input, err := ioutil.ReadFile(file)
+			require.NoError(t, err)
+			status, err := ParseStatus(string(input))
+			require.NoError(t, err)
+			actual, err := json.MarshalIndent(status, "", "  ")
+			require.NoError(t, err)
+			actual = append(actual, '\n')
+
+			golden := strings.TrimSuffix(file, ".txt") + ".golden.json"
+			if *update {
+				require.NoError(t, ioutil.WriteFile(golden, actual, 0644))
+			}
+			expected, err := ioutil.ReadFile(golden)
+			require.NoError(t, err)
+			assert.Equal(t, string(expected), string(actual))
+		})
+	}
+}
+
+var parseConnectedTests = []struct {
+	request  string
+	expected time.Duration
+}{
+	{"00:11", 11 * time.Second},
+	{"07:36", 7*time.Minute + 36*time.Second},
+	{"1:02:03", time.Hour + 2*time.Minute + 3*time.Second},
+	{"BOT", 0},
+}
+
+func TestParseConnected(t *testing.T) {
+	for _, tt := range parseConnectedTests {
+		assert.Equal(t, tt.expected, parseConnected(tt.request))
+	}
+}
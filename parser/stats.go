@@ -0,0 +1,127 @@
+/*
+Copyright 2020 Alexander Trost <galexrt@googlemail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package parser
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/galexrt/srcds_exporter/parser/models"
+)
+
+// statsMultiWordColumns the TF2 header has multi word columns, newer builds
+// join their words with underscores
+var statsMultiWordColumns = strings.NewReplacer(
+	"In (KB/s)", "In",
+	"Out (KB/s)", "Out",
+	"Map changes", "Maps",
+	"In_(KB/s)", "In",
+	"Out_(KB/s)", "Out",
+	"Map_changes", "Maps",
+)
+
+// statsColumnAliases names of the same `stats` columns in the dialects, the
+// older Source games (and HLDS) list the connects as users
+var statsColumnAliases = map[string]string{
+	"In":    "NetIn",
+	"Out":   "NetOut",
+	"Users": "Connects",
+}
+
+// statsRequiredColumns columns listed by all dialects, a header without them
+// isn't understood
+var statsRequiredColumns = []string{"CPU", "NetIn", "NetOut", "FPS"}
+
+// ParseStats parse SRCDS and HLDS `stats` command to retrieve the server
+// performance stats, the columns are taken from the header line so the TF2,
+// CS:GO and older Source dialects are supported
+func ParseStats(input string) (*models.Stats, error) {
+	input = strings.Replace(input, "\000", "", -1)
+	lines := strings.Split(input, "\n")
+	for i, line := range lines {
+		if !strings.HasPrefix(strings.TrimSpace(line), "CPU") {
+			continue
+		}
+		columns := strings.Fields(statsMultiWordColumns.Replace(line))
+		for j, column := range columns {
+			if alias, ok := statsColumnAliases[column]; ok {
+				columns[j] = alias
+			}
+		}
+		for _, values := range lines[i+1:] {
+			if fields := strings.Fields(values); len(fields) > 0 {
+				return parseStatsValues(columns, fields)
+			}
+		}
+		break
+	}
+	return nil, errors.New("no stats found in input")
+}
+
+// parseStatsValues returns the stats of the values of the columns
+func parseStatsValues(columns []string, values []string) (*models.Stats, error) {
+	for _, required := range statsRequiredColumns {
+		if !containsColumn(columns, required) {
+			return nil, fmt.Errorf("stats column %s not found in header", required)
+		}
+	}
+	if len(values) < len(columns) {
+		return nil, fmt.Errorf("stats have %d columns but %d values", len(columns), len(values))
+	}
+	stats := &models.Stats{
+		MapChanges: -1,
+		Connects:   -1,
+	}
+	for i, column := range columns {
+		value, err := strconv.ParseFloat(values[i], 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid value of stats column %s: %w", column, err)
+		}
+		switch column {
+		case "CPU":
+			stats.CPU = value
+		case "NetIn":
+			stats.NetIn = value
+		case "NetOut":
+			stats.NetOut = value
+		case "Uptime":
+			// the uptime is listed in minutes
+			stats.Uptime = time.Duration(value) * time.Minute
+		case "Maps":
+			stats.MapChanges = int(value)
+		case "FPS":
+			stats.FPS = value
+		case "Players":
+			stats.Players = int(value)
+		case "Connects":
+			stats.Connects = int(value)
+		}
+	}
+	return stats, nil
+}
+
+func containsColumn(columns []string, column string) bool {
+	for _, c := range columns {
+		if c == column {
+			return true
+		}
+	}
+	return false
+}
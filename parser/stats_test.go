@@ -0,0 +1,134 @@
+/*
+Copyright 2020 Alexander Trost <galexrt@googlemail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package parser
+
+import (
+	"testing"
+	"time"
+
+	"github.com/galexrt/srcds_exporter/parser/models"
+	"github.com/stretchr/testify/assert"
+)
+
+var parseStatsTests = []struct {
+	request  string
+	expected *models.Stats
+	errOkay  bool
+}{
+	{
+		`CPU    In (KB/s)  Out (KB/s)  Uptime  Map changes  FPS      Players  Connects
+12.50  3.21       45.67       1234    17           66.67    24       311
+`,
+		&models.Stats{
+			CPU:        12.5,
+			NetIn:      3.21,
+			NetOut:     45.67,
+			Uptime:     1234 * time.Minute,
+			MapChanges: 17,
+			FPS:        66.67,
+			Players:    24,
+			Connects:   311,
+		},
+		false,
+	},
+	{
+		`  CPU   NetIn   NetOut    Uptime  Maps   FPS   Players  Svms    +-ms   ~tick
+  8.0    123.4   2345.6      115     3  128.00      10    0.28    0.05    0.02
+`,
+		&models.Stats{
+			CPU:        8,
+			NetIn:      123.4,
+			NetOut:     2345.6,
+			Uptime:     115 * time.Minute,
+			MapChanges: 3,
+			FPS:        128,
+			Players:    10,
+			Connects:   -1,
+		},
+		false,
+	},
+	{
+		`CPU   In    Out   Uptime  Users   FPS    Players
+ 0.00  0.50  1.25      42     7  66.67       2
+`,
+		&models.Stats{
+			CPU:        0,
+			NetIn:      0.5,
+			NetOut:     1.25,
+			Uptime:     42 * time.Minute,
+			MapChanges: -1,
+			FPS:        66.67,
+			Players:    2,
+			Connects:   7,
+		},
+		false,
+	},
+	{
+		`CPU    In_(KB/s)  Out_(KB/s)  Uptime  Map_changes  FPS      Players  Connects
+ 4.75  1.02       12.34       560     6            66.66    12       98
+`,
+		&models.Stats{
+			CPU:        4.75,
+			NetIn:      1.02,
+			NetOut:     12.34,
+			Uptime:     560 * time.Minute,
+			MapChanges: 6,
+			FPS:        66.66,
+			Players:    12,
+			Connects:   98,
+		},
+		false,
+	},
+	{
+		`CPU    Uptime  Maps   Players
+ 4.75      560     6        12
+`,
+		nil,
+		true,
+	},
+	{
+		`CPU    In_KB/s  Out_KB/s  Uptime  Map_changes  FPS      Players  Connects
+ 4.75  1.02     12.34     560     6            66.66    12       98
+`,
+		nil,
+		true,
+	},
+	{
+		`CPU   In    Out   Uptime  Users   FPS    Players
+ 0.00  0.50
+`,
+		nil,
+		true,
+	},
+	{
+		`NOPE`,
+		nil,
+		true,
+	},
+}
+
+func TestParseStats(t *testing.T) {
+	for _, tt := range parseStatsTests {
+		actual, err := ParseStats(tt.request)
+		if tt.errOkay {
+			assert.Error(t, err)
+		} else {
+			assert.NoError(t, err)
+		}
+		assert.Equal(t, tt.expected, actual)
+	}
+}
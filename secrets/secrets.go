@@ -0,0 +1,98 @@
+/*
+Copyright 2020 Alexander Trost <galexrt@googlemail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package secrets resolves secrets, e.g., RCON passwords, from the
+// environment, files and secret stores.
+package secrets
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+// envRegex only the `${NAME}` form is expanded, passwords may contain `$`
+var envRegex = regexp.MustCompile(`\$\{([A-Za-z_][A-Za-z0-9_]*)\}`)
+
+// Provider is the interface a secret provider has to implement.
+type Provider interface {
+	// Get returns the secret referenced by ref.
+	Get(ctx context.Context, ref string) (string, error)
+}
+
+// Resolver resolves secret references of the form `<provider>:<ref>` using
+// the registered providers.
+type Resolver struct {
+	mu        sync.RWMutex
+	providers map[string]Provider
+}
+
+// NewResolver returns a new Resolver with the file provider registered as
+// `file`
+func NewResolver() *Resolver {
+	return &Resolver{
+		providers: map[string]Provider{
+			"file": FileProvider{},
+		},
+	}
+}
+
+// Register registers the provider under the given name
+func (r *Resolver) Register(name string, provider Provider) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.providers[name] = provider
+}
+
+// Resolve returns the secret of the `<provider>:<ref>` reference
+func (r *Resolver) Resolve(ctx context.Context, reference string) (string, error) {
+	parts := strings.SplitN(reference, ":", 2)
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return "", fmt.Errorf("secrets: invalid reference %q, expected <provider>:<ref>", reference)
+	}
+	r.mu.RLock()
+	provider, ok := r.providers[parts[0]]
+	r.mu.RUnlock()
+	if !ok {
+		return "", fmt.Errorf("secrets: provider %s not available", parts[0])
+	}
+	secret, err := provider.Get(ctx, parts[1])
+	if err != nil {
+		return "", fmt.Errorf("secrets: %s: %w", parts[0], err)
+	}
+	return secret, nil
+}
+
+// ExpandEnv replaces `${NAME}` with the value of the environment variable,
+// unset variables are an error
+func ExpandEnv(s string) (string, error) {
+	var err error
+	out := envRegex.ReplaceAllStringFunc(s, func(match string) string {
+		name := envRegex.FindStringSubmatch(match)[1]
+		value, ok := os.LookupEnv(name)
+		if !ok && err == nil {
+			err = fmt.Errorf("secrets: environment variable %s not set", name)
+		}
+		return value
+	})
+	if err != nil {
+		return "", err
+	}
+	return out, nil
+}
@@ -0,0 +1,75 @@
+/*
+Copyright 2020 Alexander Trost <galexrt@googlemail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package secrets
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestExpandEnv(t *testing.T) {
+	os.Setenv("SRCDS_TEST_PASSWORD", "secret")
+	defer os.Unsetenv("SRCDS_TEST_PASSWORD")
+	os.Unsetenv("SRCDS_TEST_UNSET")
+
+	tests := []struct {
+		input   string
+		want    string
+		wantErr bool
+	}{
+		{input: "${SRCDS_TEST_PASSWORD}", want: "secret"},
+		{input: "pre-${SRCDS_TEST_PASSWORD}-post", want: "pre-secret-post"},
+		{input: "pa$$word", want: "pa$$word"},
+		{input: "$SRCDS_TEST_PASSWORD", want: "$SRCDS_TEST_PASSWORD"},
+		{input: "${SRCDS_TEST_UNSET}", wantErr: true},
+	}
+	for _, test := range tests {
+		out, err := ExpandEnv(test.input)
+		if test.wantErr {
+			assert.Error(t, err, test.input)
+			continue
+		}
+		require.NoError(t, err, test.input)
+		assert.Equal(t, test.want, out, test.input)
+	}
+}
+
+func TestResolverFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "secrets")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "password")
+	require.NoError(t, ioutil.WriteFile(path, []byte("secret\n"), 0600))
+
+	r := NewResolver()
+	out, err := r.Resolve(context.Background(), "file:"+path)
+	require.NoError(t, err)
+	assert.Equal(t, "secret", out)
+
+	_, err = r.Resolve(context.Background(), "file:"+filepath.Join(dir, "missing"))
+	assert.Error(t, err)
+	_, err = r.Resolve(context.Background(), "unknown:ref")
+	assert.Error(t, err)
+	_, err = r.Resolve(context.Background(), "no-provider")
+	assert.Error(t, err)
+}
@@ -0,0 +1,133 @@
+/*
+Copyright 2020 Alexander Trost <galexrt@googlemail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package secrets
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// DefaultVaultTimeout timeout of requests to Vault
+const DefaultVaultTimeout = "10s"
+
+// VaultOptions options of the Vault provider
+type VaultOptions struct {
+	// Address of the Vault server, e.g., `https://vault:8200`
+	Address string `yaml:"address"`
+	// Token or TokenFile authenticate the requests, the TokenFile is read on
+	// every request so rotated tokens are picked up
+	Token     string `yaml:"token"`
+	TokenFile string `yaml:"token_file"`
+	Namespace string `yaml:"namespace"`
+	Timeout   string `yaml:"timeout"`
+}
+
+// VaultProvider reads secrets from the Vault KV secrets engine (version 1 and
+// 2) using the HTTP API. The ref is `<path>#<key>`, e.g.,
+// `secret/data/srcds#server1` for the KV version 2 engine mounted at `secret`.
+type VaultProvider struct {
+	opts   VaultOptions
+	client *http.Client
+}
+
+// NewVaultProvider returns a new VaultProvider
+func NewVaultProvider(opts VaultOptions) (*VaultProvider, error) {
+	if opts.Address == "" {
+		return nil, errors.New("vault address is empty")
+	}
+	if opts.Token == "" && opts.TokenFile == "" {
+		return nil, errors.New("vault token or token_file is required")
+	}
+	if opts.Timeout == "" {
+		opts.Timeout = DefaultVaultTimeout
+	}
+	timeout, err := time.ParseDuration(opts.Timeout)
+	if err != nil {
+		return nil, err
+	}
+	return &VaultProvider{
+		opts:   opts,
+		client: &http.Client{Timeout: timeout},
+	}, nil
+}
+
+// Get returns the key of the secret at the path
+func (v *VaultProvider) Get(ctx context.Context, ref string) (string, error) {
+	parts := strings.SplitN(ref, "#", 2)
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return "", fmt.Errorf("invalid ref %q, expected <path>#<key>", ref)
+	}
+	path, key := strings.Trim(parts[0], "/"), parts[1]
+
+	token := v.opts.Token
+	if v.opts.TokenFile != "" {
+		var err error
+		if token, err = (FileProvider{}).Get(ctx, v.opts.TokenFile); err != nil {
+			return "", err
+		}
+	}
+	req, err := http.NewRequest(http.MethodGet, strings.TrimRight(v.opts.Address, "/")+"/v1/"+path, nil)
+	if err != nil {
+		return "", err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("X-Vault-Token", token)
+	if v.opts.Namespace != "" {
+		req.Header.Set("X-Vault-Namespace", v.opts.Namespace)
+	}
+	resp, err := v.client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return "", err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("reading %s failed with status %d: %s", path, resp.StatusCode, strings.TrimSpace(string(body)))
+	}
+
+	var secret struct {
+		Data map[string]interface{} `json:"data"`
+	}
+	if err := json.Unmarshal(body, &secret); err != nil {
+		return "", err
+	}
+	data := secret.Data
+	// KV version 2 nests the secret and its metadata
+	if nested, ok := data["data"].(map[string]interface{}); ok {
+		if _, ok := data["metadata"]; ok {
+			data = nested
+		}
+	}
+	value, ok := data[key]
+	if !ok {
+		return "", fmt.Errorf("key %s not found in %s", key, path)
+	}
+	str, ok := value.(string)
+	if !ok {
+		return "", fmt.Errorf("key %s in %s is not a string", key, path)
+	}
+	return str, nil
+}
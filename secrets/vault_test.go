@@ -0,0 +1,88 @@
+/*
+Copyright 2020 Alexander Trost <galexrt@googlemail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package secrets
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newVaultStub serves KV version 1 secrets under /v1/kv/ and version 2
+// secrets under /v1/secret/data/
+func newVaultStub(t *testing.T) *httptest.Server {
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Vault-Token") != "token" {
+			http.Error(w, `{"errors":["permission denied"]}`, http.StatusForbidden)
+			return
+		}
+		switch r.URL.Path {
+		case "/v1/kv/srcds":
+			w.Write([]byte(`{"data":{"server1":"v1-secret"}}`))
+		case "/v1/secret/data/srcds":
+			w.Write([]byte(`{"data":{"data":{"server1":"v2-secret","port":27015},"metadata":{"version":3}}}`))
+		default:
+			http.Error(w, `{"errors":[]}`, http.StatusNotFound)
+		}
+	}))
+	t.Cleanup(s.Close)
+	return s
+}
+
+func TestVaultProvider(t *testing.T) {
+	s := newVaultStub(t)
+	v, err := NewVaultProvider(VaultOptions{Address: s.URL, Token: "token"})
+	require.NoError(t, err)
+
+	tests := []struct {
+		ref     string
+		want    string
+		wantErr bool
+	}{
+		{ref: "kv/srcds#server1", want: "v1-secret"},
+		{ref: "secret/data/srcds#server1", want: "v2-secret"},
+		{ref: "/secret/data/srcds#server1", want: "v2-secret"},
+		{ref: "secret/data/srcds#server2", wantErr: true},
+		{ref: "secret/data/srcds#port", wantErr: true},
+		{ref: "secret/data/missing#server1", wantErr: true},
+		{ref: "secret/data/srcds", wantErr: true},
+	}
+	for _, test := range tests {
+		out, err := v.Get(context.Background(), test.ref)
+		if test.wantErr {
+			assert.Error(t, err, test.ref)
+			continue
+		}
+		require.NoError(t, err, test.ref)
+		assert.Equal(t, test.want, out, test.ref)
+	}
+}
+
+func TestVaultProviderPermissionDenied(t *testing.T) {
+	s := newVaultStub(t)
+	v, err := NewVaultProvider(VaultOptions{Address: s.URL, Token: "wrong"})
+	require.NoError(t, err)
+	_, err = v.Get(context.Background(), "kv/srcds#server1")
+	assert.Error(t, err)
+
+	_, err = NewVaultProvider(VaultOptions{Address: s.URL})
+	assert.Error(t, err)
+}